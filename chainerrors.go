@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const chainErrorsMetricName = "oula_shares_push_chain_errors_total"
+
+// 出错的阶段，作为 stage 标签
+const (
+	stageWrite = "write" // 写入链的 .prom 文件失败
+	stageScan  = "scan"  // 读取链最新 epoch 的 share count 失败
+)
+
+// 进程生命周期内每个链各阶段的错误次数，只增不减，可以使用 rate()；以及每个链最近一次的错误
+var (
+	chainErrorsMu    sync.Mutex
+	chainErrorCounts = make(map[chainErrorKey]int64)
+	chainLastErrors  = make(map[string]chainLastError)
+)
+
+type chainErrorKey struct {
+	chain string
+	stage string
+}
+
+// /readyz 中每个链最近一次的错误，字段名属于对外约定
+type chainLastError struct {
+	Stage string `json:"stage"`
+	Error string `json:"error"`
+	At    int64  `json:"at"`
+}
+
+// 记录一个链的一次错误，可能在多个 goroutine 中同时调用
+func recordChainError(chain, stage string, err error) {
+	chainErrorsMu.Lock()
+	defer chainErrorsMu.Unlock()
+	chainErrorCounts[chainErrorKey{chain, stage}]++
+	chainLastErrors[chain] = chainLastError{Stage: stage, Error: err.Error(), At: time.Now().Unix()}
+}
+
+// 每个链最近一次错误的副本
+func chainLastErrorsSnapshot() map[string]chainLastError {
+	chainErrorsMu.Lock()
+	defer chainErrorsMu.Unlock()
+	if len(chainLastErrors) == 0 {
+		return nil
+	}
+	snapshot := make(map[string]chainLastError, len(chainLastErrors))
+	for chain, lastErr := range chainLastErrors {
+		snapshot[chain] = lastErr
+	}
+	return snapshot
+}
+
+// 出过错的链才有样本，没有出过错时这个指标族不输出
+func chainErrorsFamily(now time.Time) metricFamily {
+	chainErrorsMu.Lock()
+	defer chainErrorsMu.Unlock()
+
+	keys := make([]chainErrorKey, 0, len(chainErrorCounts))
+	for key := range chainErrorCounts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].chain != keys[j].chain {
+			return keys[i].chain < keys[j].chain
+		}
+		return keys[i].stage < keys[j].stage
+	})
+
+	family := metricFamily{name: chainErrorsMetricName, help: "Total number of errors per chain since the process started, by stage (write or scan)", counter: true}
+	for _, key := range keys {
+		family.addSample([]labelPair{{"chain", key.chain}, {"stage", key.stage}}, float64(chainErrorCounts[key]), now)
+	}
+	return family
+}
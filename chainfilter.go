@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// -chains、-exclude-chains 和 -expected-chains 指定的链名，使用数据库中的原始链名
+var (
+	includeChains  listFlag
+	excludeChains  listFlag
+	expectedChains listFlag
+)
+
+const chainPresentMetricName = "oula_shares_chain_present"
+
+var zeroMissingChains = flag.Bool("zero-missing-chains", false, "Export chains listed in -chains but missing from the query result with value 0")
+
+func init() {
+	flag.Var(&includeChains, "chains", "Only export these chains, repeatable or comma separated (e.g. -chains=aleo,quai)")
+	flag.Var(&excludeChains, "exclude-chains", "Never export these chains, repeatable or comma separated")
+	flag.Var(&expectedChains, "expected-chains", "Export these chains with value 0 when they are missing from the query result, "+
+		"and "+chainPresentMetricName+" for every chain; repeatable or comma separated")
+}
+
+// 校验链过滤参数并记录生效的过滤条件
+func parseChainFilter() error {
+	for _, chain := range excludeChains {
+		if includeChains.contains(chain) {
+			return fmt.Errorf("链 %s 同时出现在 -chains 和 -exclude-chains 中", chain)
+		}
+	}
+	if *zeroMissingChains && len(includeChains) == 0 {
+		return fmt.Errorf("-zero-missing-chains 需要同时指定 -chains")
+	}
+	for _, chain := range expectedChains {
+		if !chainSelected(chain) {
+			return fmt.Errorf("-expected-chains 中的链 %s 被 -chains 或 -exclude-chains 排除", chain)
+		}
+	}
+
+	if len(includeChains) > 0 {
+		slog.Info("只导出部分链", "chains", strings.Join(includeChains, ","))
+	}
+	if len(excludeChains) > 0 {
+		slog.Info("不导出部分链", "chains", strings.Join(excludeChains, ","))
+	}
+	return nil
+}
+
+// 查询结果中没有的 -expected-chains（以及 -zero-missing-chains 时的 -chains）按 0 导出，让缺失可以被看到
+func fillMissingChains(stats map[string]*chainStats, queriedAt time.Time) {
+	var chains []string
+	chains = append(chains, expectedChains...)
+	if *zeroMissingChains {
+		chains = append(chains, includeChains...)
+	}
+	for _, chain := range chains {
+		if _, ok := stats[chain]; !ok {
+			stats[chain] = &chainStats{UpdatedAt: queriedAt, Missing: true}
+		}
+	}
+}
+
+// 判断一个链是否需要导出
+func chainSelected(chain string) bool {
+	if len(includeChains) > 0 && !includeChains.contains(chain) {
+		return false
+	}
+	return !excludeChains.contains(chain)
+}
+
+func (l listFlag) contains(value string) bool {
+	for _, v := range l {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"oula-shares-push/dal"
+)
+
+var (
+	configFile = flag.String("config", "", "YAML file with flag values keyed by flag name (e.g. output-dir: /var/lib/prom); "+
+		"precedence is command line, then "+envPrefix+"* environment variables, then this file, then the defaults")
+	printConfig = flag.Bool("print-config", false, "Print the effective configuration with DSN passwords redacted and exit")
+)
+
+// -config、-print-config 和 -version 不能在配置文件中指定
+var commandLineOnlyFlags = map[string]bool{"config": true, "print-config": true, "version": true}
+
+// 在命令行或环境变量中指定的参数，-config 文件（包括 SIGHUP 重新加载时）不会覆盖
+var pinnedFlags = make(map[string]bool)
+
+// 加载 -config 指定的 YAML 文件，命令行或环境变量中已经指定的参数不会被覆盖；未知的键视为错误，避免拼写错误被忽略
+func loadConfigFile() error {
+	flag.Visit(func(f *flag.Flag) { pinnedFlags[f.Name] = true })
+	if *configFile == "" {
+		return nil
+	}
+	values, err := readConfigFile()
+	if err != nil {
+		return err
+	}
+	for _, key := range sortedKeys(values) {
+		if pinnedFlags[key] {
+			continue
+		}
+		if err := setFlagFromConfig(key, values[key]); err != nil {
+			return fmt.Errorf("-config %s 中的 %s: %v", *configFile, key, err)
+		}
+	}
+	return nil
+}
+
+// 读取并解析 -config 文件，检查所有的键都是可以配置的参数
+func readConfigFile() (map[string]any, error) {
+	content, err := os.ReadFile(*configFile)
+	if err != nil {
+		return nil, fmt.Errorf("读取 -config 失败: %v", err)
+	}
+	var values map[string]any
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return nil, fmt.Errorf("解析 -config %s 失败: %v", *configFile, err)
+	}
+	for key := range values {
+		if flag.Lookup(key) == nil || commandLineOnlyFlags[key] {
+			return nil, fmt.Errorf("-config %s 中的 %q 不是可以配置的参数", *configFile, key)
+		}
+	}
+	return values, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// 把 YAML 中的值设置到参数上：列表对应可以重复指定的参数，映射对应 key=value 形式的标签参数
+func setFlagFromConfig(name string, value any) error {
+	switch v := value.(type) {
+	case nil:
+		return fmt.Errorf("值为空")
+	case []any:
+		if !repeatableFlag(name) {
+			return fmt.Errorf("只能指定一个值")
+		}
+		for _, item := range v {
+			if err := flag.Set(name, fmt.Sprint(item)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]any:
+		if _, ok := flag.Lookup(name).Value.(labelsFlag); !ok {
+			return fmt.Errorf("不接受 key: value 形式的值")
+		}
+		for _, key := range sortedKeys(v) {
+			if err := flag.Set(name, fmt.Sprintf("%s=%v", key, v[key])); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return flag.Set(name, fmt.Sprint(v))
+	}
+}
+
+// 是否是可以重复指定的参数
+func repeatableFlag(name string) bool {
+	switch flag.Lookup(name).Value.(type) {
+	case *listFlag, *dsnFlag, labelsFlag:
+		return true
+	}
+	return false
+}
+
+// 按 YAML 格式输出合并后的所有参数，DSN 中的密码被隐藏
+func printEffectiveConfig() error {
+	dialect, err := dal.ParseDialect(*dbDriver)
+	if err != nil {
+		return err
+	}
+	mask := func(dsns dsnFlag) []string {
+		masked := make([]string, 0, len(dsns))
+		for _, value := range dsns {
+			// 多个数据源时为 name=dsn
+			if name, dsn, ok := strings.Cut(value, "="); ok && len(dsns) > 1 {
+				masked = append(masked, name+"="+dialect.MaskDSN(dsn))
+				continue
+			}
+			masked = append(masked, dialect.MaskDSN(value))
+		}
+		return masked
+	}
+
+	config := make(map[string]any)
+	flag.VisitAll(func(f *flag.Flag) {
+		if commandLineOnlyFlags[f.Name] {
+			return
+		}
+		switch v := f.Value.(type) {
+		case *dsnFlag:
+			config[f.Name] = mask(*v)
+		case *listFlag:
+			config[f.Name] = []string(*v)
+		case labelsFlag:
+			config[f.Name] = map[string]string(v)
+		case flag.Getter:
+			config[f.Name] = v.Get()
+		default:
+			config[f.Name] = f.Value.String()
+		}
+	})
+	out, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"oula-shares-push/dal"
+	"oula-shares-push/promth"
+)
+
+// -mode 支持的输出方式
+const (
+	modeFile        = "file"
+	modePushgateway = "pushgateway"
+	modeBoth        = "both"
+)
+
+// 是否写入 .prom 文件
+func fileModeEnabled() bool {
+	return *mode == modeFile || *mode == modeBoth
+}
+
+// 是否推送到 Pushgateway
+func pushModeEnabled() bool {
+	return *mode == modePushgateway || *mode == modeBoth
+}
+
+// 一轮查询得到的数据
+type cycleData struct {
+	chains    []string // 已排序并去除了清洗后名称冲突的链
+	stats     map[string]*chainStats
+	queriedAt time.Time
+
+	// 每个链各 epoch 的 share count，只在 -push-histogram 时保留
+	epochShares map[string]map[int64]int64
+}
+
+// 执行一轮查询并输出到各个目标，返回本轮发生的所有错误
+func runCycle(ctx context.Context, srcs []*source, pusher promth.MetricPusher) []error {
+	start := time.Now()
+	defer func() { recordCycleDuration(time.Since(start)) }()
+
+	data, errs := querySources(ctx, srcs)
+	if data == nil {
+		return errs
+	}
+	cachedData = data
+
+	return append(errs, writeOutputs(ctx, data, pusher, true)...)
+}
+
+// 把一轮的数据输出到各个目标，fresh 表示数据来自刚刚完成的查询，而不是 -write-interval 重新输出的缓存结果
+func writeOutputs(ctx context.Context, data *cycleData, pusher promth.MetricPusher, fresh bool) []error {
+	var errs []error
+	defer func() { lastOutputAt.Store(time.Now().UnixNano()) }()
+
+	// 各个输出目标互不影响，一个失败不影响其他目标的更新
+	if pushModeEnabled() {
+		if err := pushMetrics(ctx, data, pusher); err != nil {
+			slog.Error("推送到 Pushgateway 时出错", "err", err)
+			errs = append(errs, err)
+		}
+	}
+
+	errs = append(errs, sendSinks(ctx, data)...)
+
+	// 磁盘写满或只读后的冷却期内不写任何数据文件，避免每轮反复写盘
+	if inDiskCooldown() {
+		slog.Warn("磁盘写入冷却中，本轮跳过写入数据文件")
+		return errs
+	}
+
+	if *jsonOutput != "" {
+		if err := writeJSONOutput(*jsonOutput, data.queriedAt, data.chains, data.stats); err != nil {
+			slog.Error("写入 JSON 文件时出错", "file", *jsonOutput, "err", err)
+			errs = append(errs, err)
+		}
+	}
+
+	if fileModeEnabled() {
+		errs = append(errs, writeChainFiles(data, fresh)...)
+	}
+
+	return errs
+}
+
+// 查询一个数据源本轮的数据，name 作为查询耗时的 source 标签，logger 带有区分数据源的字段。
+// share count 查询失败、任何查询超时或行数超限时返回 nil，其余查询失败只记录错误。
+// 返回的 epochShares 总是填充，由 querySources 决定是否保留
+func queryStats(ctx context.Context, name string, logger *slog.Logger, store dal.ShareStore) (*cycleData, []error) {
+	var errs []error
+
+	// 本轮所有查询共用一个超时，MySQL 卡住时不会阻塞主循环
+	ctx, cancel := context.WithTimeout(ctx, *queryTimeout)
+	defer cancel()
+
+	// 从数据库获取各个链的最新分享计数
+	start := time.Now()
+	shareCounts, err := timedQuery(ctx, name, "share_counts", func() (map[string]int64, error) { return store.ShareCounts(ctx) })
+	if err != nil {
+		logQueryError(logger, "获取 share counts", err)
+		return nil, append(errs, err)
+	}
+	if len(shareCounts) == 0 {
+		if err := recordEmptyResult(logger); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	// 只有查询成功时才更新时间戳
+	queriedAt := time.Now()
+
+	stats := make(map[string]*chainStats, len(shareCounts))
+	for chain, epochCount := range shareCounts {
+		if !chainSelected(chain) {
+			continue
+		}
+		stats[chain] = &chainStats{EpochCount: epochCount, UpdatedAt: queriedAt, QueryDuration: queriedAt.Sub(start)}
+	}
+
+	// 按 epoch 的数据只在需要时查询：导出最近 -max-epochs 个 epoch、统计非零或缺失的 epoch 个数，或推送 histogram
+	histogram := pushModeEnabled() && *pushHistogram
+	var epochShares map[string]map[int64]int64
+	if *perEpoch || *exportNonzeroEpochs || *exportMissingEpochs || histogram {
+		epochShares, err = timedQuery(ctx, name, "epoch_share_counts", func() (map[string]map[int64]int64, error) {
+			return store.EpochShareCounts(ctx, *epochLookback)
+		})
+		if err != nil {
+			logQueryError(logger, "获取各 epoch 的 share counts", err)
+			errs = append(errs, err)
+		}
+		for chain, s := range stats {
+			s.NonzeroEpochs = len(epochShares[chain])
+			s.MissingEpochs = missingEpochs(epochShares[chain], *missingEpochsWindow)
+			if *perEpoch {
+				s.EpochShares = latestEpochs(epochShares[chain], *maxEpochs)
+			}
+		}
+	}
+
+	// 最大高度查询失败不影响 share count 指标的写入
+	if *exportMaxEpoch {
+		heights, err := timedQuery(ctx, name, "max_share_height", func() (map[string]int64, error) { return store.MaxHeights(ctx) })
+		if err != nil {
+			logQueryError(logger, "获取最大 epoch 高度", err)
+			errs = append(errs, err)
+		}
+		for chain, height := range heights {
+			if s, ok := stats[chain]; ok {
+				s.MaxEpoch = height
+				s.HasMaxEpoch = true
+			}
+		}
+	}
+
+	if *perUser {
+		userShares, err := timedQuery(ctx, name, "user_share_counts", func() (map[string]map[string]int64, error) {
+			return store.UserShareCounts(ctx, *topUsers)
+		})
+		if err != nil {
+			logQueryError(logger, "获取各用户的 share counts", err)
+			errs = append(errs, err)
+		}
+		for chain, users := range userShares {
+			if s, ok := stats[chain]; ok {
+				s.UserShares = users
+			}
+		}
+	}
+
+	// 按时间窗口统计的查询失败同样不影响其他指标
+	errs = append(errs, queryRecentShares(ctx, name, logger, store, stats)...)
+
+	// 超时说明数据库卡住了，部分结果也不输出
+	if ctx.Err() != nil {
+		logger.Warn("数据库查询超时，跳过本轮", "query_timeout", *queryTimeout)
+		return nil, errs
+	}
+	// 行数异常时同样不输出部分结果
+	if hasRowLimitError(errs) {
+		return nil, errs
+	}
+
+	return &cycleData{stats: stats, queriedAt: queriedAt, epochShares: epochShares}, errs
+}
+
+// 是否有查询读取的行数超过了 -max-rows
+func hasRowLimitError(errs []error) bool {
+	for _, err := range errs {
+		var limitErr *dal.RowLimitError
+		if errors.As(err, &limitErr) {
+			return true
+		}
+	}
+	return false
+}
+
+// 最近一轮是否有查询超过了 -max-rows
+var rowLimitExceeded atomic.Bool
+
+// 记录查询错误，超时和行数超限单独说明，便于与 SQL 错误区分
+func logQueryError(logger *slog.Logger, what string, err error) {
+	var limitErr *dal.RowLimitError
+	if errors.As(err, &limitErr) {
+		rowLimitExceeded.Store(true)
+		logger.Error(what+" 时读取的行数超过 -max-rows，表中的数据可能异常（例如迁移导致重复行），跳过本轮", "max_rows", limitErr.Limit, "rows", limitErr.Rows, "err", err)
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		logger.Error(what+" 超时", "query_timeout", *queryTimeout, "err", err)
+		return
+	}
+	logger.Error(what+" 时发生错误", "err", err)
+}
+
+// 把本轮数据写入 .prom 文件，每个文件的写入记录为 debug 日志，每轮只输出一条 info 汇总。
+// 过期文件按查询结果判断，重新输出缓存结果时不清理
+func writeChainFiles(data *cycleData, fresh bool) []error {
+	var errs []error
+	chains, stats := data.chains, data.stats
+	start := time.Now()
+
+	// 合并模式下所有链写入同一个文件，DB 中消失的链下一轮自然不再出现
+	if *combinedFile != "" {
+		filePath := filepath.Join(*outputDir, *combinedFile)
+		slog.Debug("正在写入指标数据", "file", filePath, "chains", len(chains))
+		if err := writeAllMetrics(filePath, chains, stats); err != nil {
+			if !isDiskError(err) {
+				slog.Error("写入文件时出错", "file", filePath, "err", err)
+			}
+			errs = append(errs, err)
+		} else {
+			slog.Debug("成功写入", "file", filePath)
+		}
+		logWriteSummary(len(chains), 1, len(errs), start)
+		return errs
+	}
+
+	// 推送每个链的最新分享计数，最多 -write-concurrency 个文件同时写入
+	written := make(map[string]bool, len(chains))
+	var mu sync.Mutex
+	var g errgroup.Group
+	g.SetLimit(max(*writeConcurrency, 1))
+	for _, chain := range chains {
+		chain := chain
+		// 构建文件路径，文件名使用清洗后的链名
+		filePath := chainFilePath(*outputDir, chain)
+		written[filePath] = true
+
+		g.Go(func() error {
+			slog.Debug("正在写入指标数据", "chain", chain, "file", filePath)
+
+			// 使用封装好的函数写文件，错误全部收集起来，不因为某个链失败而中断，
+			// 磁盘错误由 recordDiskErrors 汇总输出一条日志
+			if err := writeToPromFile(filePath, chain, stats[chain]); err != nil {
+				if !isDiskError(err) {
+					slog.Error("写入文件时出错", "chain", chain, "file", filePath, "err", err)
+				}
+				recordChainError(chain, stageWrite, err)
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			} else {
+				slog.Debug("成功写入", "chain", chain, "file", filePath)
+			}
+			return nil
+		})
+	}
+	g.Wait()
+
+	// 清理已经不存在的链留下的文件
+	if fresh {
+		removeStaleFiles(*outputDir, written)
+	}
+
+	logWriteSummary(len(chains), len(written), len(errs), start)
+	return errs
+}
+
+// 每轮写入结束后的汇总日志
+func logWriteSummary(chains, files, failed int, start time.Time) {
+	slog.Info("本轮指标数据写入完成", "dir", *outputDir, "chains", chains, "files", files, "failed", failed, "duration", time.Since(start).Round(time.Millisecond))
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"oula-shares-push/dal"
+)
+
+// 可以让单个查询失败或一直阻塞到超时的 ShareStore，数据来自内嵌的 MemoryStore
+type fakeStore struct {
+	*dal.MemoryStore
+	errs  map[string]error // 按查询名返回的错误
+	block map[string]bool  // 按查询名阻塞到 ctx 结束
+}
+
+func newFakeStore() *fakeStore {
+	s := &fakeStore{MemoryStore: dal.NewMemoryStore(), errs: make(map[string]error), block: make(map[string]bool)}
+	s.SetShareCount("aleo", 100, 1200)
+	s.SetShareCount("aleo", 101, 1350)
+	s.SetShareCount("quai", 500, 88)
+	s.SetUserShareCount("aleo", "alice", 900)
+	return s
+}
+
+func (s *fakeStore) fail(ctx context.Context, query string) error {
+	if s.block[query] {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return s.errs[query]
+}
+
+func (s *fakeStore) ShareCounts(ctx context.Context) (map[string]int64, error) {
+	if err := s.fail(ctx, "share_counts"); err != nil {
+		return nil, err
+	}
+	return s.MemoryStore.ShareCounts(ctx)
+}
+
+func (s *fakeStore) EpochShareCounts(ctx context.Context, lookback int64) (map[string]map[int64]int64, error) {
+	if err := s.fail(ctx, "epoch_share_counts"); err != nil {
+		return nil, err
+	}
+	return s.MemoryStore.EpochShareCounts(ctx, lookback)
+}
+
+func (s *fakeStore) MaxHeights(ctx context.Context) (map[string]int64, error) {
+	if err := s.fail(ctx, "max_share_height"); err != nil {
+		return nil, err
+	}
+	return s.MemoryStore.MaxHeights(ctx)
+}
+
+func (s *fakeStore) UserShareCounts(ctx context.Context, topN int) (map[string]map[string]int64, error) {
+	if err := s.fail(ctx, "user_share_counts"); err != nil {
+		return nil, err
+	}
+	return s.MemoryStore.UserShareCounts(ctx, topN)
+}
+
+// 打开所有按需执行的查询
+func enableAllQueries(t *testing.T) {
+	setFlag(t, "per-epoch", "true")
+	setFlag(t, "per-user", "true")
+	setFlag(t, "export-max-epoch", "true")
+	setFlag(t, "query-timeout", "5s")
+}
+
+func TestQueryStats(t *testing.T) {
+	enableAllQueries(t)
+	data, errs := queryStats(context.Background(), "", slog.Default(), newFakeStore())
+	if len(errs) != 0 {
+		t.Fatalf("查询返回错误: %v", errs)
+	}
+	if data == nil {
+		t.Fatal("查询成功时应该返回数据")
+	}
+	aleo := data.stats["aleo"]
+	if aleo == nil || aleo.EpochCount != 1350 || aleo.MaxEpoch != 101 || !aleo.HasMaxEpoch {
+		t.Errorf("aleo 的数据为 %+v", aleo)
+	}
+	if aleo != nil && (aleo.UserShares["alice"] != 900 || len(aleo.EpochShares) != 2) {
+		t.Errorf("aleo 的用户或 epoch 数据为 %v、%v", aleo.UserShares, aleo.EpochShares)
+	}
+	if quai := data.stats["quai"]; quai == nil || quai.EpochCount != 88 {
+		t.Errorf("quai 的数据为 %+v", quai)
+	}
+}
+
+// share count 查询失败时本轮没有数据
+func TestQueryStatsShareCountFailure(t *testing.T) {
+	enableAllQueries(t)
+	store := newFakeStore()
+	store.errs["share_counts"] = errors.New("connection refused")
+
+	data, errs := queryStats(context.Background(), "", slog.Default(), store)
+	if data != nil {
+		t.Errorf("share count 查询失败时应该返回 nil，得到 %+v", data)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], store.errs["share_counts"]) {
+		t.Errorf("返回的错误为 %v", errs)
+	}
+}
+
+// 其他查询失败只记录错误，share count 照常输出
+func TestQueryStatsOtherQueryFailure(t *testing.T) {
+	for _, query := range []string{"epoch_share_counts", "max_share_height", "user_share_counts"} {
+		t.Run(query, func(t *testing.T) {
+			enableAllQueries(t)
+			store := newFakeStore()
+			store.errs[query] = errors.New("syntax error")
+
+			data, errs := queryStats(context.Background(), "", slog.Default(), store)
+			if data == nil {
+				t.Fatalf("%s 失败时不应丢弃本轮数据", query)
+			}
+			if len(errs) != 1 || !errors.Is(errs[0], store.errs[query]) {
+				t.Errorf("返回的错误为 %v", errs)
+			}
+			if s := data.stats["aleo"]; s == nil || s.EpochCount != 1350 {
+				t.Errorf("aleo 的数据为 %+v", s)
+			}
+		})
+	}
+}
+
+// 超时或行数超限时部分结果也不输出
+func TestQueryStatsDropsPartialData(t *testing.T) {
+	t.Run("超时", func(t *testing.T) {
+		enableAllQueries(t)
+		setFlag(t, "query-timeout", "50ms")
+		store := newFakeStore()
+		store.block["user_share_counts"] = true
+
+		data, errs := queryStats(context.Background(), "", slog.Default(), store)
+		if data != nil {
+			t.Errorf("超时时应该返回 nil，得到 %+v", data)
+		}
+		if len(errs) == 0 || !errors.Is(errs[len(errs)-1], context.DeadlineExceeded) {
+			t.Errorf("返回的错误为 %v", errs)
+		}
+	})
+
+	t.Run("行数超限", func(t *testing.T) {
+		enableAllQueries(t)
+		store := newFakeStore()
+		store.errs["epoch_share_counts"] = &dal.RowLimitError{Query: "epoch_share_counts", Limit: 10, Rows: 11}
+
+		data, errs := queryStats(context.Background(), "", slog.Default(), store)
+		if data != nil {
+			t.Errorf("行数超限时应该返回 nil，得到 %+v", data)
+		}
+		if !hasRowLimitError(errs) {
+			t.Errorf("返回的错误中没有 RowLimitError: %v", errs)
+		}
+	})
+}
+
+// 没有数据的一轮不写任何文件，有数据时每个链写一个文件
+func TestRunCycle(t *testing.T) {
+	enableAllQueries(t)
+	dir := t.TempDir()
+	setFlag(t, "mode", modeFile)
+	setFlag(t, "output-dir", dir)
+	oldCached := cachedData
+	t.Cleanup(func() { cachedData = oldCached })
+
+	store := newFakeStore()
+	setSources(t, testSource("", store))
+
+	store.errs["share_counts"] = errors.New("connection refused")
+	if errs := runCycle(context.Background(), sources, nil); len(errs) != 1 {
+		t.Errorf("share count 查询失败时返回 %v", errs)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("没有数据时不应写入文件，目录中有 %d 个文件", len(entries))
+	}
+
+	delete(store.errs, "share_counts")
+	store.errs["max_share_height"] = errors.New("syntax error")
+	if errs := runCycle(context.Background(), sources, nil); len(errs) != 1 {
+		t.Errorf("最大高度查询失败时返回 %v", errs)
+	}
+	for _, chain := range []string{"aleo", "quai"} {
+		if _, err := os.Stat(chainFilePath(dir, chain)); err != nil {
+			t.Errorf("没有写入 %s 的文件: %v", chain, err)
+		}
+	}
+	if cachedData == nil || cachedData.queriedAt.After(time.Now()) {
+		t.Errorf("cachedData 没有更新")
+	}
+}
+
+// 通过 MemoryStore 端到端地检查 -epoch-lookback 和 -min-share-count 对各项结果的影响
+func TestQueryStatsMemoryStore(t *testing.T) {
+	tests := []struct {
+		name          string
+		lookback      string
+		minShareCount int64
+		wantCount     int64           // aleo 的 share count
+		wantNonzero   int             // aleo 有 share 的 epoch 个数
+		wantEpochs    map[int64]int64 // aleo 导出的最近 2 个 epoch
+		wantMissing   int64
+		wantQuai      bool
+		wantUsers     int // aleo 导出的用户个数
+	}{
+		{"不限制", "0", 0, 5, 20, map[int64]int64{19: 190, 20: 5}, 0, true, 2},
+		{"lookback", "5", 0, 5, 5, map[int64]int64{19: 190, 20: 5}, 0, true, 2},
+		{"min-share-count", "0", 10, 190, 18, map[int64]int64{18: 180, 19: 190}, 1, false, 1},
+		{"lookback 和 min-share-count", "5", 10, 190, 3, map[int64]int64{18: 180, 19: 190}, 1, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, "per-epoch", "true")
+			setFlag(t, "export-nonzero-epochs", "true")
+			setFlag(t, "export-missing-epochs", "true")
+			setFlag(t, "export-max-epoch", "true")
+			setFlag(t, "max-epochs", "2")
+			setFlag(t, "epoch-lookback", tt.lookback)
+			setFlag(t, "per-user", "true")
+
+			// aleo 的 epoch 1 到 20，epoch 17 和 20 的 share 很少；quai 只有一个 share 很少的 epoch
+			store := dal.NewMemoryStore()
+			store.MinShareCount = tt.minShareCount
+			for epoch := int64(1); epoch <= 20; epoch++ {
+				store.SetShareCount("aleo", epoch, epoch*10)
+			}
+			store.SetShareCount("aleo", 17, 3)
+			store.SetShareCount("aleo", 20, 5)
+			store.SetShareCount("quai", 1, 5)
+			store.SetUserShareCount("aleo", "alice", 150)
+			store.SetUserShareCount("aleo", "bob", 4)
+			store.SetUserShareCount("aleo", "carol", 0)
+
+			data, errs := queryStats(context.Background(), "", slog.Default(), store)
+			if len(errs) != 0 || data == nil {
+				t.Fatalf("查询返回 %v, %v", data, errs)
+			}
+			aleo := data.stats["aleo"]
+			if aleo == nil {
+				t.Fatal("结果中没有 aleo")
+			}
+			if aleo.EpochCount != tt.wantCount {
+				t.Errorf("share count 为 %d，应为 %d", aleo.EpochCount, tt.wantCount)
+			}
+			if aleo.NonzeroEpochs != tt.wantNonzero {
+				t.Errorf("有 share 的 epoch 为 %d 个，应为 %d 个", aleo.NonzeroEpochs, tt.wantNonzero)
+			}
+			if aleo.MissingEpochs != tt.wantMissing {
+				t.Errorf("缺失的 epoch 为 %d 个，应为 %d 个", aleo.MissingEpochs, tt.wantMissing)
+			}
+			if len(aleo.EpochShares) != len(tt.wantEpochs) {
+				t.Errorf("导出的 epoch 为 %v，应为 %v", aleo.EpochShares, tt.wantEpochs)
+			}
+			for epoch, count := range tt.wantEpochs {
+				if aleo.EpochShares[epoch] != count {
+					t.Errorf("导出的 epoch 为 %v，应为 %v", aleo.EpochShares, tt.wantEpochs)
+					break
+				}
+			}
+			// 最大高度不受 -min-share-count 影响
+			if !aleo.HasMaxEpoch || aleo.MaxEpoch != 20 {
+				t.Errorf("最大高度为 %d", aleo.MaxEpoch)
+			}
+			if _, ok := data.stats["quai"]; ok != tt.wantQuai {
+				t.Errorf("结果中是否有 quai: %v，应为 %v", ok, tt.wantQuai)
+			}
+			if len(aleo.UserShares) != tt.wantUsers {
+				t.Errorf("导出的用户为 %v，应为 %d 个", aleo.UserShares, tt.wantUsers)
+			}
+		})
+	}
+}
+
+// 按不同的 -write-concurrency 写入 200 个链的文件
+func BenchmarkWriteChainFiles(b *testing.B) {
+	counts := make(map[string]int64, 200)
+	for i := 0; i < 200; i++ {
+		counts[fmt.Sprintf("chain%03d", i)] = int64(i * 100)
+	}
+	data := testCycleData(time.Now(), counts)
+
+	for _, concurrency := range []int{1, 4, 16} {
+		b.Run("concurrency="+strconv.Itoa(concurrency), func(b *testing.B) {
+			setFlag(b, "output-dir", b.TempDir())
+			setFlag(b, "write-concurrency", strconv.Itoa(concurrency))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if errs := writeChainFiles(data, true); len(errs) != 0 {
+					b.Fatal(errs)
+				}
+			}
+		})
+	}
+}
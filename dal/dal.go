@@ -0,0 +1,160 @@
+package dal
+
+import (
+	"context"
+	"time"
+)
+
+// 获取每个链最近 lookback 个 epoch 中 share_count 不小于 minShareCount（至少为 1）的行，
+// 返回 map[chain]map[epoch]count，lookback 为 0 时不限制
+func GetShareCounts(ctx context.Context, db Queryer, table Table, lookback, minShareCount int64) (map[string]map[int64]int64, error) {
+	c := table.columns()
+	query := "SELECT " + c.Chain + ", " + c.Epoch + ", " + c.Count + " FROM " + table.String() + " t WHERE " + c.Count + " >= " + table.Dialect.Placeholder(1)
+	args := []any{max(minShareCount, 1)}
+	if lookback > 0 {
+		// 每个链只取最大 epoch 往前 lookback 个 epoch 以内的行，避免扫描全部历史数据
+		query += " AND " + c.Epoch + " > (SELECT MAX(m." + c.Epoch + ") FROM " + table.String() + " m WHERE m." + c.Chain + " = t." + c.Chain + ") - " + table.Dialect.Placeholder(2)
+		args = append(args, lookback)
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shareCounts := make(map[string]map[int64]int64)
+
+	n := 0
+	for rows.Next() {
+		var chain string
+		var epoch, count int64
+		n++
+		if err := CheckRowLimit("epoch_share_counts", n); err != nil {
+			return nil, err
+		}
+		if err := rows.Scan(&chain, &epoch, &count); err != nil {
+			return nil, err
+		}
+		if shareCounts[chain] == nil {
+			shareCounts[chain] = make(map[int64]int64)
+		}
+		shareCounts[chain][epoch] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return shareCounts, nil
+}
+
+// 获取每个链已记录的最大 epoch 高度
+func GetMaxShareHeight(ctx context.Context, db Queryer, table Table) (map[string]int64, error) {
+	c := table.columns()
+	rows, err := db.QueryContext(ctx, "SELECT "+c.Chain+", MAX("+c.Epoch+") AS max_epoch FROM "+table.String()+" GROUP BY "+c.Chain)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	heights := make(map[string]int64)
+
+	n := 0
+	for rows.Next() {
+		var chain string
+		var height int64
+		n++
+		if err := CheckRowLimit("max_share_height", n); err != nil {
+			return nil, err
+		}
+		if err := rows.Scan(&chain, &height); err != nil {
+			return nil, err
+		}
+		heights[chain] = height
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return heights, nil
+}
+
+// 获取每个链最新 epoch 中 share_count 最多的 topN 个用户，share_count 小于 minShareCount（至少为 1）的用户不返回，
+// 返回 map[chain]map[user]count
+func GetUserShareCounts(ctx context.Context, db Queryer, table Table, topN int, minShareCount int64) (map[string]map[string]int64, error) {
+	// user 在 PostgreSQL 中是保留字，需要加引号
+	user := table.Dialect.quote("user")
+	c := table.columns()
+	query := "SELECT u." + c.Chain + ", u." + user + ", u." + c.Count + " FROM " + table.String() + " u" +
+		" JOIN (SELECT " + c.Chain + ", MAX(" + c.Epoch + ") AS epoch FROM " + table.String() + " GROUP BY " + c.Chain + ") l" +
+		" ON u." + c.Chain + " = l." + c.Chain + " AND u." + c.Epoch + " = l.epoch" +
+		" WHERE u." + c.Count + " >= " + table.Dialect.Placeholder(1) + " ORDER BY u." + c.Chain + ", u." + c.Count + " DESC"
+	rows, err := db.QueryContext(ctx, query, max(minShareCount, 1))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userCounts := make(map[string]map[string]int64)
+
+	n := 0
+	for rows.Next() {
+		var chain, name string
+		var count int64
+		n++
+		if err := CheckRowLimit("user_share_counts", n); err != nil {
+			return nil, err
+		}
+		if err := rows.Scan(&chain, &name, &count); err != nil {
+			return nil, err
+		}
+		// 结果按 share_count 降序排列，每个链只保留前 topN 个用户
+		if len(userCounts[chain]) >= topN {
+			continue
+		}
+		if userCounts[chain] == nil {
+			userCounts[chain] = make(map[string]int64)
+		}
+		userCounts[chain][name] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return userCounts, nil
+}
+
+// 获取每个链 column 列不早于 since 的行的 share_count 之和，即最近一段时间新增的 share
+func GetRecentShareCounts(ctx context.Context, db Queryer, table Table, column string, since time.Time) (map[string]int64, error) {
+	c := table.columns()
+	query := "SELECT " + c.Chain + ", SUM(" + c.Count + ") FROM " + table.String() + " WHERE " + table.Dialect.quote(column) + " >= " + table.Dialect.Placeholder(1) + " GROUP BY " + c.Chain
+	rows, err := db.QueryContext(ctx, query, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+
+	n := 0
+	for rows.Next() {
+		var chain string
+		var count int64
+		n++
+		if err := CheckRowLimit("recent_shares", n); err != nil {
+			return nil, err
+		}
+		if err := rows.Scan(&chain, &count); err != nil {
+			return nil, err
+		}
+		counts[chain] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
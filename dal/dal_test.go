@@ -0,0 +1,160 @@
+package dal
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 各数据库中标识符和占位符的写法，查询中的 {table}、{users}、{user}、{created_at}、{1}、{2} 按此替换
+var dialectSyntax = []struct {
+	dialect Dialect
+	names   map[string]string
+}{
+	{MySQL, map[string]string{
+		"{table}": "`shares_epoch_counts`", "{users}": "`shares_user_epoch_counts`",
+		"{user}": "`user`", "{created_at}": "`created_at`", "{1}": "?", "{2}": "?",
+	}},
+	{Postgres, map[string]string{
+		"{table}": `"shares_epoch_counts"`, "{users}": `"shares_user_epoch_counts"`,
+		"{user}": `"user"`, "{created_at}": `"created_at"`, "{1}": "$1", "{2}": "$2",
+	}},
+	{SQLite, map[string]string{
+		"{table}": `"shares_epoch_counts"`, "{users}": `"shares_user_epoch_counts"`,
+		"{user}": `"user"`, "{created_at}": `"created_at"`, "{1}": "?", "{2}": "?",
+	}},
+}
+
+func expandQuery(query string, names map[string]string) string {
+	for name, value := range names {
+		query = strings.ReplaceAll(query, name, value)
+	}
+	return query
+}
+
+// 固定每种数据库生成的 SQL 文本和参数
+func TestQueries(t *testing.T) {
+	ctx := context.Background()
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, d := range dialectSyntax {
+		table := Table{Dialect: d.dialect, Name: "shares_epoch_counts"}
+		userTable := Table{Dialect: d.dialect, Name: "shares_user_epoch_counts"}
+		q := func(query string) string { return expandQuery(query, d.names) }
+
+		t.Run(string(d.dialect), func(t *testing.T) {
+			t.Run("ShareCounts", func(t *testing.T) {
+				db, fake := newFakeDB(t)
+				fake.expect(q("SELECT chain, MAX(epoch) AS latest_epoch FROM {table} GROUP BY chain")).
+					returns([]string{"chain", "latest_epoch"}, []driver.Value{"aleo", int64(101)})
+				fake.expect(q("SELECT share_count FROM {table} WHERE chain = {1} AND epoch = {2}"), "aleo", int64(101)).
+					returns([]string{"share_count"}, []driver.Value{int64(1350)})
+
+				counts, err := NewSQLStore(db, table, userTable, 0).ShareCounts(ctx)
+				if err != nil || counts["aleo"] != 1350 {
+					t.Errorf("返回 %v, %v", counts, err)
+				}
+			})
+
+			t.Run("ShareCounts 和 minShareCount", func(t *testing.T) {
+				db, fake := newFakeDB(t)
+				fake.expect(q("SELECT chain, MAX(epoch) AS latest_epoch FROM {table} WHERE share_count >= {1} GROUP BY chain"), int64(10)).
+					returns([]string{"chain", "latest_epoch"}, []driver.Value{"aleo", int64(101)}, []driver.Value{"quai", int64(501)})
+				fake.expect(q("SELECT share_count FROM {table} WHERE chain = {1} AND epoch = {2}"), "aleo", int64(101)).
+					returns([]string{"share_count"}, []driver.Value{int64(1350)})
+				fake.expect(q("SELECT share_count FROM {table} WHERE chain = {1} AND epoch = {2}"), "quai", int64(501)).
+					returns([]string{"share_count"}, []driver.Value{int64(92)})
+
+				counts, err := NewSQLStore(db, table, userTable, 10).ShareCounts(ctx)
+				if err != nil || counts["aleo"] != 1350 || counts["quai"] != 92 {
+					t.Errorf("返回 %v, %v", counts, err)
+				}
+			})
+
+			t.Run("EpochShareCounts", func(t *testing.T) {
+				db, fake := newFakeDB(t)
+				fake.expect(q("SELECT chain, epoch, share_count FROM {table} t WHERE share_count >= {1}"), int64(1)).
+					returns([]string{"chain", "epoch", "share_count"}, []driver.Value{"aleo", int64(101), int64(1350)})
+				fake.expect(q("SELECT chain, epoch, share_count FROM {table} t WHERE share_count >= {1}"+
+					" AND epoch > (SELECT MAX(m.epoch) FROM {table} m WHERE m.chain = t.chain) - {2}"), int64(10), int64(1000)).
+					returns([]string{"chain", "epoch", "share_count"})
+
+				store := NewSQLStore(db, table, userTable, 0)
+				shares, err := store.EpochShareCounts(ctx, 0)
+				if err != nil || shares["aleo"][101] != 1350 {
+					t.Errorf("返回 %v, %v", shares, err)
+				}
+				store = NewSQLStore(db, table, userTable, 10)
+				if _, err := store.EpochShareCounts(ctx, 1000); err != nil {
+					t.Error(err)
+				}
+			})
+
+			t.Run("MaxHeights", func(t *testing.T) {
+				db, fake := newFakeDB(t)
+				fake.expect(q("SELECT chain, MAX(epoch) AS max_epoch FROM {table} GROUP BY chain")).
+					returns([]string{"chain", "max_epoch"}, []driver.Value{"aleo", int64(103)})
+
+				heights, err := NewSQLStore(db, table, userTable, 0).MaxHeights(ctx)
+				if err != nil || heights["aleo"] != 103 {
+					t.Errorf("返回 %v, %v", heights, err)
+				}
+			})
+
+			t.Run("UserShareCounts", func(t *testing.T) {
+				db, fake := newFakeDB(t)
+				userQuery := q("SELECT u.chain, u.{user}, u.share_count FROM {users} u" +
+					" JOIN (SELECT chain, MAX(epoch) AS epoch FROM {users} GROUP BY chain) l" +
+					" ON u.chain = l.chain AND u.epoch = l.epoch" +
+					" WHERE u.share_count >= {1} ORDER BY u.chain, u.share_count DESC")
+				fake.expect(userQuery, int64(1)).
+					returns([]string{"chain", "user", "share_count"},
+						[]driver.Value{"aleo", "alice", int64(900)}, []driver.Value{"aleo", "bob", int64(510)})
+				fake.expect(userQuery, int64(10)).
+					returns([]string{"chain", "user", "share_count"}, []driver.Value{"aleo", "alice", int64(900)})
+
+				users, err := NewSQLStore(db, table, userTable, 0).UserShareCounts(ctx, 1)
+				if err != nil || len(users["aleo"]) != 1 || users["aleo"]["alice"] != 900 {
+					t.Errorf("返回 %v, %v", users, err)
+				}
+				if _, err := NewSQLStore(db, table, userTable, 10).UserShareCounts(ctx, 1); err != nil {
+					t.Error(err)
+				}
+			})
+
+			t.Run("RecentShareCounts", func(t *testing.T) {
+				db, fake := newFakeDB(t)
+				fake.expect(q("SELECT chain, SUM(share_count) FROM {table} WHERE {created_at} >= {1} GROUP BY chain"), since).
+					returns([]string{"chain", "sum"}, []driver.Value{"aleo", int64(42)})
+
+				counts, err := NewSQLStore(db, table, userTable, 0).RecentShareCounts(ctx, "created_at", since)
+				if err != nil || counts["aleo"] != 42 {
+					t.Errorf("返回 %v, %v", counts, err)
+				}
+			})
+		})
+	}
+}
+
+// 自定义库名和列名同样按数据库的写法拼接
+func TestQueriesCustomTable(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		want    string
+	}{
+		{MySQL, "SELECT pool_chain, MAX(height) AS max_epoch FROM `pool`.`epochs` GROUP BY pool_chain"},
+		{Postgres, `SELECT pool_chain, MAX(height) AS max_epoch FROM "pool"."epochs" GROUP BY pool_chain`},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.dialect), func(t *testing.T) {
+			db, fake := newFakeDB(t)
+			fake.expect(tt.want).returns([]string{"chain", "max_epoch"})
+			table := Table{Dialect: tt.dialect, Schema: "pool", Name: "epochs", Columns: Columns{Chain: "pool_chain", Epoch: "height"}}
+			if _, err := GetMaxShareHeight(context.Background(), db, table); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package dal
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5"
+)
+
+// 支持的数据库，表结构相同，只有占位符和标识符的写法不同
+type Dialect string
+
+const (
+	MySQL    Dialect = "mysql"
+	Postgres Dialect = "postgres"
+	// 用于本地开发，DSN 是数据库文件的路径
+	SQLite Dialect = "sqlite"
+)
+
+// 解析 -db-driver 的取值
+func ParseDialect(name string) (Dialect, error) {
+	switch d := Dialect(name); d {
+	case MySQL, Postgres, SQLite:
+		return d, nil
+	default:
+		return "", fmt.Errorf("不支持的数据库类型 %q，可选值为 %s、%s 或 %s", name, MySQL, Postgres, SQLite)
+	}
+}
+
+// database/sql 中注册的驱动名
+func (d Dialect) driverName() string {
+	switch d {
+	case Postgres:
+		return "pgx"
+	case SQLite:
+		return "sqlite"
+	default:
+		return "mysql"
+	}
+}
+
+// 按驱动校验 DSN 的格式，启动时就能发现写错的 DSN
+func (d Dialect) ValidateDSN(dsn string) error {
+	var err error
+	switch d {
+	case Postgres:
+		_, err = pgx.ParseConfig(dsn)
+	case SQLite:
+		// 文件路径或 file: URI，由驱动在打开时检查
+		if dsn == "" {
+			err = fmt.Errorf("数据库文件路径为空")
+		}
+	default:
+		_, err = mysql.ParseDSN(dsn)
+	}
+	if err != nil {
+		return fmt.Errorf("%s DSN 格式不正确: %v", d, err)
+	}
+	return nil
+}
+
+// 第 n 个（从 1 开始）参数的占位符
+func (d Dialect) Placeholder(n int) string {
+	if d == Postgres {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
+
+// 给校验过的标识符加上引号
+func (d Dialect) quote(name string) string {
+	if d == Postgres || d == SQLite {
+		return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+	}
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// 没有指定库名时使用的当前库（MySQL）或 schema（PostgreSQL），SQLite 不使用
+func (d Dialect) currentSchema() string {
+	if d == Postgres {
+		return "current_schema()"
+	}
+	return "DATABASE()"
+}
+
+// 把 DSN 中的密码替换为 xxxxx，用于日志和错误信息；无法解析时整个隐藏
+func (d Dialect) MaskDSN(dsn string) string {
+	switch d {
+	case Postgres:
+		if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+			return u.Redacted()
+		}
+		// key=value 格式
+		fields := strings.Fields(dsn)
+		for i, field := range fields {
+			if strings.HasPrefix(field, "password=") {
+				fields[i] = "password=xxxxx"
+			}
+		}
+		return strings.Join(fields, " ")
+	case SQLite:
+		// 文件路径中没有密码
+		return dsn
+	default:
+		cfg, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			return "<unparsable DSN>"
+		}
+		if cfg.Passwd != "" {
+			cfg.Passwd = "xxxxx"
+		}
+		return cfg.FormatDSN()
+	}
+}
@@ -0,0 +1,137 @@
+package dal
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// 按顺序检查收到的 SQL 和参数的 database/sql 驱动，用于固定各个数据库生成的查询，
+// 作用与 go-sqlmock 相同
+type fakeDB struct {
+	t *testing.T
+
+	mu       sync.Mutex
+	expected []*fakeQuery
+}
+
+// 预期的一次查询和它返回的结果
+type fakeQuery struct {
+	query   string
+	args    []driver.Value
+	columns []string
+	rows    [][]driver.Value
+}
+
+func newFakeDB(t *testing.T) (*sql.DB, *fakeDB) {
+	f := &fakeDB{t: t}
+	db := sql.OpenDB(f)
+	t.Cleanup(func() {
+		db.Close()
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		for _, q := range f.expected {
+			t.Errorf("没有执行预期的查询 %s", q.query)
+		}
+	})
+	return db, f
+}
+
+// 添加下一次预期的查询
+func (f *fakeDB) expect(query string, args ...driver.Value) *fakeQuery {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	q := &fakeQuery{query: query, args: args}
+	f.expected = append(f.expected, q)
+	return q
+}
+
+// 设置查询返回的列和行
+func (q *fakeQuery) returns(columns []string, rows ...[]driver.Value) {
+	q.columns, q.rows = columns, rows
+}
+
+func (f *fakeDB) query(query string, args []driver.NamedValue) (driver.Rows, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.expected) == 0 {
+		f.t.Errorf("意外的查询 %s", query)
+		return nil, errors.New("意外的查询")
+	}
+	q := f.expected[0]
+	f.expected = f.expected[1:]
+
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	if query != q.query {
+		f.t.Errorf("查询为\n%s\n应为\n%s", query, q.query)
+		return nil, errors.New("查询不一致")
+	}
+	if len(values) != len(q.args) || (len(values) > 0 && !reflect.DeepEqual(values, q.args)) {
+		f.t.Errorf("查询 %s 的参数为 %v，应为 %v", query, values, q.args)
+		return nil, errors.New("参数不一致")
+	}
+	return &fakeRows{columns: q.columns, rows: q.rows}, nil
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{f}, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return fakeDriver{f} }
+
+type fakeDriver struct{ db *fakeDB }
+
+func (d fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{d.db}, nil }
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.db, query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return nil, errors.New("不支持事务") }
+
+func (c fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.db.query(query, args)
+}
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, fmt.Errorf("不支持 Exec")
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	named := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return s.db.query(s.query, named)
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
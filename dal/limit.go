@@ -0,0 +1,26 @@
+package dal
+
+import "fmt"
+
+// 每个查询最多读取的行数，超过时停止读取并返回 *RowLimitError，0 不限制。
+// 用于防止表中数据异常膨胀时把所有行读入内存
+var MaxRows int
+
+// 查询结果超过 MaxRows 时返回的错误
+type RowLimitError struct {
+	Query string
+	Limit int
+	Rows  int // 停止读取前已经读取的行数，实际结果可能更多
+}
+
+func (e *RowLimitError) Error() string {
+	return fmt.Sprintf("查询 %s 读取了 %d 行，超过 %d 行的上限，已停止读取", e.Query, e.Rows, e.Limit)
+}
+
+// 读取到第 n 行时调用，超过 MaxRows 时返回错误
+func CheckRowLimit(query string, n int) error {
+	if MaxRows > 0 && n > MaxRows {
+		return &RowLimitError{Query: query, Limit: MaxRows, Rows: n}
+	}
+	return nil
+}
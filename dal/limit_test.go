@@ -0,0 +1,33 @@
+package dal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCheckRowLimit(t *testing.T) {
+	old := MaxRows
+	t.Cleanup(func() { MaxRows = old })
+
+	MaxRows = 0
+	if err := CheckRowLimit("epoch_share_counts", 1000000); err != nil {
+		t.Errorf("MaxRows 为 0 时返回 %v", err)
+	}
+
+	MaxRows = 10
+	if err := CheckRowLimit("epoch_share_counts", 10); err != nil {
+		t.Errorf("读取到第 10 行时返回 %v", err)
+	}
+	err := CheckRowLimit("epoch_share_counts", 11)
+	var limitErr *RowLimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("读取到第 11 行时返回 %v", err)
+	}
+	if limitErr.Query != "epoch_share_counts" || limitErr.Limit != 10 || limitErr.Rows != 11 {
+		t.Errorf("返回 %+v", limitErr)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "11 行") || !strings.Contains(msg, "10 行") {
+		t.Errorf("错误信息中没有行数: %s", msg)
+	}
+}
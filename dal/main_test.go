@@ -0,0 +1,14 @@
+package dal
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+)
+
+// 测试中不输出查询失败时的日志
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
@@ -0,0 +1,149 @@
+package dal
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// 保存在内存中的 ShareStore，用于测试和不连接数据库的场景。
+// 数据按 chain -> epoch -> share_count 保存，Err 不为 nil 时所有查询都返回该错误，
+// MinShareCount 与 NewSQLStore 的 minShareCount 含义相同
+type MemoryStore struct {
+	mu     sync.Mutex
+	shares map[string]map[int64]int64
+	users  map[string]map[string]int64
+
+	Err           error
+	MinShareCount int64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{shares: make(map[string]map[int64]int64), users: make(map[string]map[string]int64)}
+}
+
+// 设置指定链在指定 epoch 的 share_count
+func (m *MemoryStore) SetShareCount(chain string, epoch, count int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.shares[chain] == nil {
+		m.shares[chain] = make(map[int64]int64)
+	}
+	m.shares[chain][epoch] = count
+}
+
+// 设置指定链最新 epoch 中某个用户的 share_count
+func (m *MemoryStore) SetUserShareCount(chain, user string, count int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.users[chain] == nil {
+		m.users[chain] = make(map[string]int64)
+	}
+	m.users[chain][user] = count
+}
+
+func (m *MemoryStore) ShareCounts(ctx context.Context) (map[string]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	counts := make(map[string]int64, len(m.shares))
+	for chain, epochs := range m.shares {
+		epochs = m.aboveMin(epochs)
+		if len(epochs) == 0 {
+			continue
+		}
+		counts[chain] = epochs[latestEpoch(epochs)]
+	}
+	return counts, nil
+}
+
+func (m *MemoryStore) EpochShareCounts(ctx context.Context, lookback int64) (map[string]map[int64]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	result := make(map[string]map[int64]int64)
+	for chain, epochs := range m.shares {
+		latest := latestEpoch(epochs)
+		for epoch, count := range epochs {
+			// 与 SQL 实现一致，只返回达到阈值（至少为 1）并且在 lookback 范围内的 epoch
+			if count < max(m.MinShareCount, 1) || (lookback > 0 && epoch <= latest-lookback) {
+				continue
+			}
+			if result[chain] == nil {
+				result[chain] = make(map[int64]int64)
+			}
+			result[chain][epoch] = count
+		}
+	}
+	return result, nil
+}
+
+func (m *MemoryStore) MaxHeights(ctx context.Context) (map[string]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	heights := make(map[string]int64, len(m.shares))
+	for chain, epochs := range m.shares {
+		heights[chain] = latestEpoch(epochs)
+	}
+	return heights, nil
+}
+
+func (m *MemoryStore) UserShareCounts(ctx context.Context, topN int) (map[string]map[string]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	result := make(map[string]map[string]int64)
+	for chain, users := range m.users {
+		names := make([]string, 0, len(users))
+		for name, count := range users {
+			if count >= max(m.MinShareCount, 1) {
+				names = append(names, name)
+			}
+		}
+		sort.Slice(names, func(i, j int) bool { return users[names[i]] > users[names[j]] })
+		if len(names) > topN {
+			names = names[:topN]
+		}
+		for _, name := range names {
+			if result[chain] == nil {
+				result[chain] = make(map[string]int64)
+			}
+			result[chain][name] = users[name]
+		}
+	}
+	return result, nil
+}
+
+// 去掉 share_count 小于 MinShareCount 的 epoch
+func (m *MemoryStore) aboveMin(epochs map[int64]int64) map[int64]int64 {
+	if m.MinShareCount <= 0 {
+		return epochs
+	}
+	result := make(map[int64]int64, len(epochs))
+	for epoch, count := range epochs {
+		if count >= m.MinShareCount {
+			result[epoch] = count
+		}
+	}
+	return result
+}
+
+func latestEpoch(epochs map[int64]int64) int64 {
+	var latest int64
+	first := true
+	for epoch := range epochs {
+		if first || epoch > latest {
+			latest, first = epoch, false
+		}
+	}
+	return latest
+}
@@ -0,0 +1,93 @@
+package dal
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// 执行查询的对象，*sql.DB 和 *StmtCache 都满足
+type Queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+var (
+	_ Queryer = (*sql.DB)(nil)
+	_ Queryer = (*StmtCache)(nil)
+)
+
+// 按 SQL 文本缓存预编译语句，每种查询在一个连接池上只 prepare 一次，之后每轮复用。
+// 重新建立连接后调用 SetDB 切换到新的连接池
+type StmtCache struct {
+	db *sql.DB
+
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
+}
+
+func NewStmtCache(db *sql.DB) *StmtCache {
+	return &StmtCache{db: db, stmts: make(map[string]*sql.Stmt)}
+}
+
+func (c *StmtCache) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	stmt, err := c.prepare(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.QueryContext(ctx, args...)
+}
+
+// prepare 失败时退回到不使用预编译语句的查询，错误由 Scan 返回
+func (c *StmtCache) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	stmt, err := c.prepare(ctx, query)
+	if err != nil {
+		c.mu.Lock()
+		db := c.db
+		c.mu.Unlock()
+		return db.QueryRowContext(ctx, query, args...)
+	}
+	return stmt.QueryRowContext(ctx, args...)
+}
+
+// 切换到新的连接池：关闭绑定在旧连接池上的预编译语句，之后的查询在新的连接池上重新 prepare
+func (c *StmtCache) SetDB(db *sql.DB) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeStmts()
+	c.db = db
+}
+
+// 关闭所有预编译语句，不关闭连接池
+func (c *StmtCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closeStmts()
+}
+
+// 调用方需要持有 c.mu
+func (c *StmtCache) closeStmts() error {
+	var firstErr error
+	for query, stmt := range c.stmts {
+		if err := stmt.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(c.stmts, query)
+	}
+	return firstErr
+}
+
+func (c *StmtCache) prepare(ctx context.Context, query string) (*sql.Stmt, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if stmt, ok := c.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := c.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	c.stmts[query] = stmt
+	return stmt, nil
+}
@@ -0,0 +1,44 @@
+package dal
+
+import (
+	"context"
+	"testing"
+)
+
+// 连接池被关闭后切换到新的连接池，之后的查询在新的连接池上重新 prepare
+func TestStmtCacheSetDB(t *testing.T) {
+	ctx := context.Background()
+	const query = "SELECT share_count FROM shares_epoch_counts WHERE chain = ?"
+	old := openTestDB(t, PoolConfig{MaxOpenConns: 1},
+		testSharesSchema, `INSERT INTO shares_epoch_counts VALUES ('aleo', 1, 100)`)
+	cache := NewStmtCache(old)
+	t.Cleanup(func() { cache.Close() })
+
+	var count int64
+	if err := cache.QueryRowContext(ctx, query, "aleo").Scan(&count); err != nil || count != 100 {
+		t.Fatalf("第一次查询返回 %d, %v", count, err)
+	}
+
+	old.Close()
+	if err := cache.QueryRowContext(ctx, query, "aleo").Scan(&count); err == nil {
+		t.Fatal("连接池关闭后查询应该失败")
+	}
+
+	replacement := openTestDB(t, PoolConfig{MaxOpenConns: 1},
+		testSharesSchema, `INSERT INTO shares_epoch_counts VALUES ('aleo', 1, 200)`)
+	cache.SetDB(replacement)
+	if err := cache.QueryRowContext(ctx, query, "aleo").Scan(&count); err != nil || count != 200 {
+		t.Fatalf("切换连接池后 QueryRowContext 返回 %d, %v", count, err)
+	}
+	rows, err := cache.QueryContext(ctx, query, "aleo")
+	if err != nil {
+		t.Fatalf("切换连接池后 QueryContext 返回 %v", err)
+	}
+	defer rows.Close()
+	if !rows.Next() || rows.Scan(&count) != nil || count != 200 {
+		t.Errorf("切换连接池后查询到 %d, %v", count, rows.Err())
+	}
+	if len(cache.stmts) != 1 {
+		t.Errorf("缓存了 %d 个预编译语句，应为 1 个", len(cache.stmts))
+	}
+}
@@ -0,0 +1,135 @@
+package dal
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// 查询 share 数据的数据源，主循环只依赖这个接口，便于替换成其他存储或测试用的实现
+type ShareStore interface {
+	// 每个链最新 epoch 的 share_count
+	ShareCounts(ctx context.Context) (map[string]int64, error)
+	// 每个链最近 lookback 个 epoch 的 share_count，lookback 为 0 时不限制
+	EpochShareCounts(ctx context.Context, lookback int64) (map[string]map[int64]int64, error)
+	// 每个链已记录的最大 epoch 高度
+	MaxHeights(ctx context.Context) (map[string]int64, error)
+	// 每个链最新 epoch 中 share_count 最多的 topN 个用户
+	UserShareCounts(ctx context.Context, topN int) (map[string]map[string]int64, error)
+}
+
+// 可以按时间统计新增 share 的 ShareStore，需要表中有记录写入时间的列
+type RecentShareStore interface {
+	// 每个链 column 列不早于 since 的行的 share_count 之和
+	RecentShareCounts(ctx context.Context, column string, since time.Time) (map[string]int64, error)
+}
+
+// 基于 SQL 数据库的 ShareStore，MySQL、PostgreSQL 和 SQLite 共用
+type SQLStore struct {
+	db            Queryer
+	table         Table
+	userTable     Table
+	minShareCount int64
+
+	// 读取某个链最新 epoch 的 share_count 失败时调用，这个链在本轮结果中缺失，可以为 nil
+	ChainError func(chain string, err error)
+}
+
+// 创建 SQLStore，userTable 只在查询按用户统计的数据时使用。
+// share_count 小于 minShareCount 的 epoch 视为不存在，0 表示不过滤
+func NewSQLStore(db Queryer, table, userTable Table, minShareCount int64) *SQLStore {
+	return &SQLStore{db: db, table: table, userTable: userTable, minShareCount: minShareCount}
+}
+
+func (s *SQLStore) ShareCounts(ctx context.Context) (map[string]int64, error) {
+	c := s.table.columns()
+	query := "SELECT " + c.Chain + ", MAX(" + c.Epoch + ") AS latest_epoch FROM " + s.table.String()
+	var args []any
+	if s.minShareCount > 0 {
+		query += " WHERE " + c.Count + " >= " + s.table.Dialect.Placeholder(1)
+		args = append(args, s.minShareCount)
+	}
+	latest, err := s.latestEpochs(ctx, query+" GROUP BY "+c.Chain, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	shareCounts := make(map[string]int64)
+	for _, l := range latest {
+		// 查询该链的最新高度的 share_count
+		count, err := s.shareCountAtEpoch(ctx, l.chain, l.epoch)
+		// 超时后剩下的查询都会失败，直接放弃本轮
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			slog.Error("Error getting share count", "chain", l.chain, "epoch", l.epoch, "err", err)
+			if s.ChainError != nil {
+				s.ChainError(l.chain, err)
+			}
+			continue
+		}
+		shareCounts[l.chain] = count
+	}
+
+	return shareCounts, nil
+}
+
+type chainEpoch struct {
+	chain string
+	epoch int64
+}
+
+// 读取每个链的最新 epoch。读完后才关闭结果集，之后再逐个查询 share_count，
+// 同一时间只占用一个连接，-db-max-open-conns=1 时不会互相等待
+func (s *SQLStore) latestEpochs(ctx context.Context, query string, args ...any) ([]chainEpoch, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var latest []chainEpoch
+	n := 0
+	for rows.Next() {
+		n++
+		if err := CheckRowLimit("share_counts", n); err != nil {
+			return nil, err
+		}
+		var l chainEpoch
+		if err := rows.Scan(&l.chain, &l.epoch); err != nil {
+			return nil, err
+		}
+		latest = append(latest, l)
+	}
+	return latest, rows.Err()
+}
+
+// 获取指定链在指定 epoch 高度的 share_count
+func (s *SQLStore) shareCountAtEpoch(ctx context.Context, chain string, epoch int64) (int64, error) {
+	var shareCount int64
+	d := s.table.Dialect
+	c := s.table.columns()
+	query := "SELECT " + c.Count + " FROM " + s.table.String() + " WHERE " + c.Chain + " = " + d.Placeholder(1) + " AND " + c.Epoch + " = " + d.Placeholder(2)
+	err := s.db.QueryRowContext(ctx, query, chain, epoch).Scan(&shareCount)
+	if err != nil {
+		return 0, err
+	}
+	return shareCount, nil
+}
+
+func (s *SQLStore) EpochShareCounts(ctx context.Context, lookback int64) (map[string]map[int64]int64, error) {
+	return GetShareCounts(ctx, s.db, s.table, lookback, s.minShareCount)
+}
+
+func (s *SQLStore) MaxHeights(ctx context.Context) (map[string]int64, error) {
+	return GetMaxShareHeight(ctx, s.db, s.table)
+}
+
+func (s *SQLStore) UserShareCounts(ctx context.Context, topN int) (map[string]map[string]int64, error) {
+	return GetUserShareCounts(ctx, s.db, s.userTable, topN, s.minShareCount)
+}
+
+func (s *SQLStore) RecentShareCounts(ctx context.Context, column string, since time.Time) (map[string]int64, error) {
+	return GetRecentShareCounts(ctx, s.db, s.table, column, since)
+}
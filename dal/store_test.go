@@ -0,0 +1,52 @@
+package dal
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 在临时目录中打开 SQLite 库并执行建表和插入语句
+func openTestDB(t *testing.T, pool PoolConfig, stmts ...string) *sql.DB {
+	t.Helper()
+	db, err := InitDB(SQLite, filepath.Join(t.TempDir(), "shares.db"), pool)
+	if err != nil {
+		t.Fatalf("打开 SQLite: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("执行 %q: %v", stmt, err)
+		}
+	}
+	return db
+}
+
+const testSharesSchema = `CREATE TABLE shares_epoch_counts (
+	chain TEXT NOT NULL, epoch INTEGER NOT NULL, share_count INTEGER NOT NULL,
+	PRIMARY KEY (chain, epoch))`
+
+// -db-max-open-conns=1 时 ShareCounts 不能在结果集打开时再发起查询
+func TestShareCountsSingleConnection(t *testing.T) {
+	db := openTestDB(t, PoolConfig{MaxOpenConns: 1}, testSharesSchema,
+		`INSERT INTO shares_epoch_counts VALUES ('aleo', 100, 1200), ('aleo', 101, 1350), ('quai', 500, 88), ('quai', 501, 92)`)
+	table := Table{Dialect: SQLite, Name: "shares_epoch_counts"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	counts, err := NewSQLStore(db, table, Table{}, 0).ShareCounts(ctx)
+	if err != nil {
+		t.Fatalf("ShareCounts: %v", err)
+	}
+	want := map[string]int64{"aleo": 1350, "quai": 92}
+	if len(counts) != len(want) {
+		t.Fatalf("返回 %v，应为 %v", counts, want)
+	}
+	for chain, n := range want {
+		if counts[chain] != n {
+			t.Errorf("%s 的 share count 为 %d，应为 %d", chain, counts[chain], n)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package dal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// 查询的源表，Schema 为空时使用 DSN 中的默认数据库，Dialect 为空时按 MySQL 处理
+type Table struct {
+	Dialect Dialect
+	Schema  string
+	Name    string
+	Columns Columns
+}
+
+// 查询使用的列名，为空的字段使用默认的 chain、epoch 和 share_count
+type Columns struct {
+	Chain string
+	Epoch string
+	Count string
+}
+
+// 默认的源表
+var DefaultTable = Table{Dialect: MySQL, Name: "shares_epoch_counts"}
+
+// 填充默认值后的列名
+func (t Table) columns() Columns {
+	c := t.Columns
+	if c.Chain == "" {
+		c.Chain = "chain"
+	}
+	if c.Epoch == "" {
+		c.Epoch = "epoch"
+	}
+	if c.Count == "" {
+		c.Count = "share_count"
+	}
+	return c
+}
+
+// 查询依赖的列
+func (t Table) requiredColumns() []string {
+	c := t.columns()
+	return []string{c.Chain, c.Epoch, c.Count}
+}
+
+// 校验表名、库名和列名只包含字母、数字、_ 和 $，拼接进 SQL 前必须先调用
+func (t Table) Validate() error {
+	if !isValidIdentifier(t.Name) {
+		return fmt.Errorf("表名 %q 不合法，只能包含字母、数字、_ 和 $", t.Name)
+	}
+	if t.Schema != "" && !isValidIdentifier(t.Schema) {
+		return fmt.Errorf("库名 %q 不合法，只能包含字母、数字、_ 和 $", t.Schema)
+	}
+	for _, column := range t.requiredColumns() {
+		if !isValidIdentifier(column) {
+			return fmt.Errorf("列名 %q 不合法，只能包含字母、数字、_ 和 $", column)
+		}
+	}
+	return nil
+}
+
+// 加上引号的完整表名，用于拼接 SQL
+func (t Table) String() string {
+	if t.Schema == "" {
+		return t.Dialect.quote(t.Name)
+	}
+	return t.Dialect.quote(t.Schema) + "." + t.Dialect.quote(t.Name)
+}
+
+// 检查表存在并且包含查询需要的列，缺少的列在一条错误中全部列出
+func CheckTable(ctx context.Context, db *sql.DB, t Table) error {
+	return checkColumns(ctx, db, t, t.requiredColumns())
+}
+
+// 与 CheckTable 相同，检查的是 GetUserShareCounts 使用的按用户统计的表
+func CheckUserTable(ctx context.Context, db *sql.DB, t Table) error {
+	return checkColumns(ctx, db, t, append(t.requiredColumns(), "user"))
+}
+
+// 检查表中有 column 列，用于按时间统计等可选功能
+func CheckColumn(ctx context.Context, db *sql.DB, t Table, column string) error {
+	if !isValidIdentifier(column) {
+		return fmt.Errorf("列名 %q 不合法，只能包含字母、数字、_ 和 $", column)
+	}
+	return checkColumns(ctx, db, t, []string{column})
+}
+
+func checkColumns(ctx context.Context, db *sql.DB, t Table, required []string) error {
+	d := t.Dialect
+	query := fmt.Sprintf("SELECT column_name FROM information_schema.columns WHERE table_schema = COALESCE(NULLIF(%s, ''), %s) AND table_name = %s",
+		d.Placeholder(1), d.currentSchema(), d.Placeholder(2))
+	if d == SQLite {
+		// SQLite 没有 information_schema，库名对应 ATTACH 的数据库名，默认为 main
+		query = "SELECT name FROM pragma_table_info(?2, COALESCE(NULLIF(?1, ''), 'main'))"
+	}
+	rows, err := db.QueryContext(ctx, query, t.Schema, t.Name)
+	if err != nil {
+		return fmt.Errorf("检查表 %s 失败: %v", t, err)
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return fmt.Errorf("检查表 %s 失败: %v", t, err)
+		}
+		columns[strings.ToLower(column)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("检查表 %s 失败: %v", t, err)
+	}
+
+	if len(columns) == 0 {
+		return fmt.Errorf("表 %s 不存在或没有访问权限", t)
+	}
+	var missing []string
+	for _, column := range required {
+		if !columns[strings.ToLower(column)] {
+			missing = append(missing, column)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("表 %s 缺少列: %s", t, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func isValidIdentifier(name string) bool {
+	if name == "" || len(name) > 64 {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '$':
+		default:
+			return false
+		}
+	}
+	return true
+}
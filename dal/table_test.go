@@ -0,0 +1,174 @@
+package dal
+
+import (
+	"context"
+	"database/sql/driver"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTableValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		table   Table
+		wantErr string
+	}{
+		{"默认表", DefaultTable, ""},
+		{"库名和自定义列", Table{Schema: "pool_1", Name: "shares$v2", Columns: Columns{Chain: "coin", Epoch: "height", Count: "cnt"}}, ""},
+		{"空表名", Table{}, "表名"},
+		{"表名中有空格", Table{Name: "shares epoch"}, "表名"},
+		{"表名中有分号", Table{Name: "shares;DROP TABLE x"}, "表名"},
+		{"表名中有引号", Table{Name: "shares`"}, "表名"},
+		{"表名过长", Table{Name: strings.Repeat("a", 65)}, "表名"},
+		{"库名中有点", Table{Schema: "a.b", Name: "shares"}, "库名"},
+		{"列名中有横线", Table{Name: "shares", Columns: Columns{Count: "share-count"}}, "列名"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.table.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate 返回 %v，应该是%s不合法的错误", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTableString(t *testing.T) {
+	tests := []struct {
+		table Table
+		want  string
+	}{
+		{Table{Dialect: MySQL, Name: "shares"}, "`shares`"},
+		{Table{Dialect: MySQL, Schema: "pool", Name: "shares"}, "`pool`.`shares`"},
+		{Table{Name: "shares"}, "`shares`"},
+		{Table{Dialect: Postgres, Schema: "pool", Name: "shares"}, `"pool"."shares"`},
+		{Table{Dialect: SQLite, Name: "shares"}, `"shares"`},
+	}
+	for _, tt := range tests {
+		if got := tt.table.String(); got != tt.want {
+			t.Errorf("%+v 的表名为 %s，应为 %s", tt.table, got, tt.want)
+		}
+	}
+	// 标识符中的引号需要转义
+	if got := MySQL.quote("a`b"); got != "`a``b`" {
+		t.Errorf("MySQL 转义为 %s", got)
+	}
+	if got := Postgres.quote(`a"b`); got != `"a""b"` {
+		t.Errorf("PostgreSQL 转义为 %s", got)
+	}
+}
+
+// MySQL 和 PostgreSQL 通过 information_schema 检查列
+func TestCheckTableInformationSchema(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		query   string
+	}{
+		{MySQL, "SELECT column_name FROM information_schema.columns WHERE table_schema = COALESCE(NULLIF(?, ''), DATABASE()) AND table_name = ?"},
+		{Postgres, "SELECT column_name FROM information_schema.columns WHERE table_schema = COALESCE(NULLIF($1, ''), current_schema()) AND table_name = $2"},
+	}
+	column := func(name string) []driver.Value { return []driver.Value{name} }
+	ctx := context.Background()
+
+	for _, tt := range tests {
+		t.Run(string(tt.dialect), func(t *testing.T) {
+			table := Table{Dialect: tt.dialect, Name: "shares_epoch_counts"}
+
+			t.Run("所有列都存在", func(t *testing.T) {
+				db, fake := newFakeDB(t)
+				// 列名不区分大小写
+				fake.expect(tt.query, "", "shares_epoch_counts").
+					returns([]string{"column_name"}, column("CHAIN"), column("epoch"), column("share_count"), column("created_at"))
+				if err := CheckTable(ctx, db, table); err != nil {
+					t.Error(err)
+				}
+			})
+
+			t.Run("缺少列", func(t *testing.T) {
+				db, fake := newFakeDB(t)
+				fake.expect(tt.query, "", "shares_epoch_counts").returns([]string{"column_name"}, column("chain"))
+				err := CheckTable(ctx, db, table)
+				if err == nil || !strings.Contains(err.Error(), "缺少列: epoch, share_count") {
+					t.Errorf("CheckTable 返回 %v", err)
+				}
+			})
+
+			t.Run("表不存在", func(t *testing.T) {
+				db, fake := newFakeDB(t)
+				fake.expect(tt.query, "pool", "shares_epoch_counts").returns([]string{"column_name"})
+				withSchema := table
+				withSchema.Schema = "pool"
+				err := CheckTable(ctx, db, withSchema)
+				if err == nil || !strings.Contains(err.Error(), "不存在") || !strings.Contains(err.Error(), withSchema.String()) {
+					t.Errorf("CheckTable 返回 %v", err)
+				}
+			})
+
+			t.Run("用户表缺少 user 列", func(t *testing.T) {
+				db, fake := newFakeDB(t)
+				fake.expect(tt.query, "", "shares_epoch_counts").
+					returns([]string{"column_name"}, column("chain"), column("epoch"), column("share_count"))
+				err := CheckUserTable(ctx, db, table)
+				if err == nil || !strings.Contains(err.Error(), "缺少列: user") {
+					t.Errorf("CheckUserTable 返回 %v", err)
+				}
+			})
+		})
+	}
+}
+
+// SQLite 通过 pragma_table_info 检查列，库名对应 ATTACH 的数据库名
+func TestCheckTableSQLite(t *testing.T) {
+	attached := filepath.Join(t.TempDir(), "archive.db")
+	// 只使用一个连接并且不关闭空闲连接，ATTACH 对之后的查询都有效
+	db := openTestDB(t, PoolConfig{MaxOpenConns: 1, MaxIdleConns: 1},
+		testSharesSchema,
+		`CREATE TABLE partial (chain TEXT, Epoch INTEGER)`,
+		`ATTACH DATABASE '`+attached+`' AS archive`,
+		`CREATE TABLE archive.shares_epoch_counts (chain TEXT, epoch INTEGER, share_count INTEGER, user TEXT)`,
+	)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		table   Table
+		check   func(Table) error
+		wantErr string
+	}{
+		{"所有列都存在", Table{Name: "shares_epoch_counts"}, nil, ""},
+		{"缺少列", Table{Name: "partial"}, nil, "缺少列: share_count"},
+		{"表不存在", Table{Name: "missing"}, nil, "不存在"},
+		{"自定义列", Table{Name: "partial", Columns: Columns{Count: "epoch"}}, nil, ""},
+		{"ATTACH 的库", Table{Schema: "archive", Name: "shares_epoch_counts"}, nil, ""},
+		{"main 库中没有 user 列", Table{Name: "shares_epoch_counts"}, func(t Table) error { return CheckUserTable(ctx, db, t) }, "缺少列: user"},
+		{"ATTACH 的库中有 user 列", Table{Schema: "archive", Name: "shares_epoch_counts"}, func(t Table) error { return CheckUserTable(ctx, db, t) }, ""},
+		{"可选列", Table{Name: "shares_epoch_counts"}, func(t Table) error { return CheckColumn(ctx, db, t, "created_at") }, "缺少列: created_at"},
+		{"可选列名不合法", Table{Name: "shares_epoch_counts"}, func(t Table) error { return CheckColumn(ctx, db, t, "created_at; --") }, "不合法"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.table.Dialect = SQLite
+			check := tt.check
+			if check == nil {
+				check = func(t Table) error { return CheckTable(ctx, db, t) }
+			}
+			err := check(tt.table)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Error(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("返回 %v，应包含 %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package dal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// 注册到 MySQL 驱动的 TLS 配置名
+const mysqlTLSConfigName = "oula-shares-push"
+
+// 连接数据库使用的证书文件
+type TLSFiles struct {
+	CA         string
+	Cert       string
+	Key        string
+	SkipVerify bool
+}
+
+// 是否指定了任何 TLS 参数
+func (f TLSFiles) Enabled() bool {
+	return f.CA != "" || f.Cert != "" || f.Key != "" || f.SkipVerify
+}
+
+// 加载证书并注册为 MySQL 驱动的 TLS 配置，返回 tls 参数指向该配置的 DSN。
+// DSN 中没有 tls 参数时自动加上，已经指定了其他值时返回错误
+func RegisterMySQLTLS(dsn string, files TLSFiles) (string, error) {
+	config := &tls.Config{InsecureSkipVerify: files.SkipVerify}
+
+	if files.CA != "" {
+		ca, err := os.ReadFile(files.CA)
+		if err != nil {
+			return "", fmt.Errorf("读取 CA 文件 %s 失败: %v", files.CA, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return "", fmt.Errorf("CA 文件 %s 中没有有效的 PEM 证书", files.CA)
+		}
+		config.RootCAs = pool
+	}
+
+	if (files.Cert == "") != (files.Key == "") {
+		return "", fmt.Errorf("客户端证书和私钥必须同时指定")
+	}
+	if files.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(files.Cert, files.Key)
+		if err != nil {
+			return "", fmt.Errorf("加载客户端证书 %s/%s 失败: %v", files.Cert, files.Key, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if err := mysql.RegisterTLSConfig(mysqlTLSConfigName, config); err != nil {
+		return "", err
+	}
+
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", fmt.Errorf("mysql DSN 格式不正确: %v", err)
+	}
+	if cfg.TLSConfig != "" && cfg.TLSConfig != mysqlTLSConfigName {
+		return "", fmt.Errorf("DSN 中已经指定了 tls=%s，与 -db-tls-* 参数冲突", cfg.TLSConfig)
+	}
+	cfg.TLSConfig = mysqlTLSConfigName
+	return cfg.FormatDSN(), nil
+}
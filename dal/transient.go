@@ -0,0 +1,46 @@
+package dal
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net"
+	"syscall"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// 可以重试的 MySQL 错误码：1213 死锁，1205 锁等待超时
+var transientMySQLErrors = map[uint16]bool{
+	1205: true,
+	1213: true,
+}
+
+// 可以重试的 PostgreSQL SQLSTATE：死锁、序列化失败、锁不可用
+var transientPostgresErrors = map[string]bool{
+	"40P01": true,
+	"40001": true,
+	"55P03": true,
+}
+
+// 判断查询错误是否是临时的，重试可能成功；语法错误、权限不足等返回 false。
+// 上下文超时或取消不在这里判断，由调用方根据剩余时间决定
+func IsTransient(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return transientMySQLErrors[mysqlErr.Number]
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return transientPostgresErrors[pgErr.Code]
+	}
+	// 驱动自己的读写超时
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
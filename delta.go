@@ -0,0 +1,31 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// 上一轮成功查询时各链的 share count，进程重启后从头计算
+type previousCount struct {
+	count int64
+	at    time.Time
+}
+
+var previousCounts = make(map[string]previousCount)
+
+// 计算每个链与上一轮成功查询之间的差值，只在查询成功时调用，失败的轮次不会更新基准。
+// 第一次出现的链或者数值变小（计数被重置）时差值为 0
+func computeDeltas(stats map[string]*chainStats) {
+	for chain, s := range stats {
+		prev, ok := previousCounts[chain]
+		switch {
+		case !ok:
+		case s.EpochCount < prev.count:
+			slog.Info("share count 变小，视为计数重置，本轮差值记为 0", "chain", chain, "previous", prev.count, "current", s.EpochCount)
+		default:
+			s.Delta = s.EpochCount - prev.count
+			s.DeltaInterval = s.UpdatedAt.Sub(prev.at)
+		}
+		previousCounts[chain] = previousCount{count: s.EpochCount, at: s.UpdatedAt}
+	}
+}
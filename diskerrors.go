@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const diskErrorsMetricName = "oula_shares_push_disk_errors_total"
+
+// 磁盘写满或只读时的错误统计和冷却状态
+var (
+	diskErrorsTotal   int
+	diskCooldownUntil time.Time
+	diskMu            sync.Mutex
+)
+
+// 判断是否是磁盘写满（ENOSPC）或文件系统只读（EROFS）导致的错误
+func isDiskError(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EROFS)
+}
+
+// 是否处于磁盘错误后的冷却期，冷却期内不写入数据文件
+func inDiskCooldown() bool {
+	diskMu.Lock()
+	defer diskMu.Unlock()
+	return time.Now().Before(diskCooldownUntil)
+}
+
+// 统计本轮的磁盘错误，出现磁盘错误时只输出一条汇总日志并进入冷却期
+func recordDiskErrors(errs []error) {
+	n := 0
+	var first error
+	for _, err := range errs {
+		if isDiskError(err) {
+			if first == nil {
+				first = err
+			}
+			n++
+		}
+	}
+	if n == 0 {
+		return
+	}
+
+	diskMu.Lock()
+	defer diskMu.Unlock()
+	diskErrorsTotal += n
+	diskCooldownUntil = time.Now().Add(*diskErrorCooldown)
+	slog.Error("本轮有文件因磁盘已满或只读写入失败，暂停写入", "files", n, "cooldown", *diskErrorCooldown, "err", first)
+}
+
+// 进程启动以来的磁盘错误总数
+func diskErrorCount() int {
+	diskMu.Lock()
+	defer diskMu.Unlock()
+	return diskErrorsTotal
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 不通过命令行传递 DSN 时使用的环境变量，避免密码出现在 ps 输出和 unit 文件中
+const opsDSNEnv = "OPS_DSN"
+
+var opsDSNFile = flag.String("opsDsn-file", "", "File containing the database DSN, used when -opsDsn is empty; the "+opsDSNEnv+" environment variable is used when both are empty")
+
+// 按 -opsDsn、-opsDsn-file、OPS_DSN 的优先级确定只有一个数据源时的 DSN
+func resolveDSN() (string, error) {
+	if len(opsDSNs) == 1 {
+		return opsDSNs[0], nil
+	}
+	if *opsDSNFile != "" {
+		content, err := os.ReadFile(*opsDSNFile)
+		if err != nil {
+			return "", fmt.Errorf("读取 -opsDsn-file 失败: %v", err)
+		}
+		dsn := strings.TrimRight(string(content), "\r\n")
+		if dsn == "" {
+			return "", fmt.Errorf("-opsDsn-file %s 为空", *opsDSNFile)
+		}
+		return dsn, nil
+	}
+	if dsn := os.Getenv(opsDSNEnv); dsn != "" {
+		return dsn, nil
+	}
+	return "", fmt.Errorf("需要通过 -opsDsn、-opsDsn-file 或环境变量 %s 指定数据库 DSN", opsDSNEnv)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"oula-shares-push/dal"
+)
+
+// 更新时间每次运行都不同，与 golden 文件比较前替换掉
+var timestampSample = regexp.MustCompile(`(?m)^(oula_shares_last_update_timestamp_seconds\{[^}]*\}) (\S+)$`)
+
+// 把 testdata/sample.sql 导入 SQLite，执行一轮并与 testdata/e2e 中的 golden 文件比较
+func TestEndToEndSQLite(t *testing.T) {
+	sample, err := os.ReadFile(filepath.Join("testdata", "sample.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := dal.InitDB(dal.SQLite, filepath.Join(t.TempDir(), "shares.db"), dal.PoolConfig{MaxOpenConns: 2, MaxIdleConns: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(sample)); err != nil {
+		t.Fatalf("导入 sample.sql: %v", err)
+	}
+
+	oldTable, oldCached := sourceTable, cachedData
+	sourceTable = dal.Table{Dialect: dal.SQLite, Name: "shares_epoch_counts"}
+	t.Cleanup(func() { sourceTable, cachedData = oldTable, oldCached })
+
+	dir := t.TempDir()
+	setFlag(t, "mode", modeFile)
+	setFlag(t, "output-dir", dir)
+	setFlag(t, "per-user", "true")
+	conn := &dbConn{}
+	conn.setDB(db)
+	t.Cleanup(conn.close)
+	setSources(t, &source{conn: conn})
+
+	start := time.Now()
+	if errs := runCycle(context.Background(), sources, nil); len(errs) != 0 {
+		t.Fatalf("本轮返回错误: %v", errs)
+	}
+
+	goldens, err := filepath.Glob(filepath.Join("testdata", "e2e", "*.prom.golden"))
+	if err != nil || len(goldens) == 0 {
+		t.Fatalf("没有找到 golden 文件: %v", err)
+	}
+	for _, golden := range goldens {
+		name := filepath.Base(golden[:len(golden)-len(".golden")])
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("没有输出 %s: %v", name, err)
+			continue
+		}
+		for _, m := range timestampSample.FindAllSubmatch(got, -1) {
+			ts, err := strconv.ParseFloat(string(m[2]), 64)
+			if err != nil || ts < float64(start.Unix()) || ts > float64(time.Now().Unix()) {
+				t.Errorf("%s 中的更新时间 %s 不是本轮的时间", name, m[2])
+			}
+		}
+		got = timestampSample.ReplaceAll(got, []byte("$1 <timestamp>"))
+
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(want) {
+			t.Errorf("%s 与 %s 不一致:\n%s", name, golden, got)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(goldens) {
+		t.Errorf("输出目录中有 %d 个文件，golden 文件有 %d 个", len(entries), len(goldens))
+	}
+}
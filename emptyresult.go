@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+const emptyResultsMetricName = "oula_shares_push_empty_results_total"
+
+var emptyResultError = flag.Bool("empty-result-error", false, "Count a share count query that succeeds but returns no rows as an error of the cycle")
+
+// 查询成功但没有返回任何行，通常是表被重命名或数据停止写入
+var errEmptyResult = errors.New("share count 查询没有返回任何数据")
+
+// 进程生命周期内 share count 查询返回空结果的次数
+var emptyResultsTotal atomic.Int64
+
+// 记录一次空结果，与数据库错误分开统计；-empty-result-error 时返回错误，让 oula_shares_push_success 变为 0
+func recordEmptyResult(logger *slog.Logger) error {
+	emptyResultsTotal.Add(1)
+	logger.Warn("share count 查询成功但没有返回任何数据，请检查表是否还在写入", "table", sourceTable.String())
+	if *emptyResultError {
+		return errEmptyResult
+	}
+	return nil
+}
+
+func emptyResultsFamily(now time.Time) metricFamily {
+	family := metricFamily{name: emptyResultsMetricName, help: "Total number of share count queries that succeeded but returned no rows", counter: true}
+	family.addSample(nil, float64(emptyResultsTotal.Load()), now)
+	return family
+}
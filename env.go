@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"unicode"
+
+	"oula-shares-push/dal"
+)
+
+// 所有参数都可以通过 OULA_ 开头的环境变量指定，例如 -output-dir 对应 OULA_OUTPUT_DIR，-opsDsn 对应 OULA_OPS_DSN
+const envPrefix = "OULA_"
+
+// 参数名对应的环境变量名：- 换成 _，驼峰处断开，全部大写
+func flagEnvName(name string) string {
+	var b strings.Builder
+	b.WriteString(envPrefix)
+	prevLower := false
+	for _, r := range name {
+		switch {
+		case r == '-':
+			b.WriteByte('_')
+			prevLower = false
+			continue
+		case unicode.IsUpper(r) && prevLower:
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+		prevLower = unicode.IsLower(r) || unicode.IsDigit(r)
+	}
+	return b.String()
+}
+
+// 用环境变量设置命令行中没有指定的参数，优先级：命令行 > 环境变量 > -config 文件 > 默认值。
+// 必须在 flag.Parse 之后、loadConfigFile 之前调用
+func loadEnvFlags() error {
+	setOnCommandLine := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { setOnCommandLine[f.Name] = true })
+
+	var fromEnv []*flag.Flag
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if err != nil || setOnCommandLine[f.Name] || f.Name == "print-config" {
+			return
+		}
+		value, ok := os.LookupEnv(flagEnvName(f.Name))
+		if !ok {
+			return
+		}
+		if setErr := flag.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("环境变量 %s 的值不合法: %v", flagEnvName(f.Name), setErr)
+			return
+		}
+		fromEnv = append(fromEnv, f)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fromEnv {
+		slog.Info("从环境变量读取参数", "env", flagEnvName(f.Name), "flag", f.Name, "value", redactedFlagValue(f))
+	}
+	return nil
+}
+
+// 用于日志的参数值，DSN 中的密码被隐藏
+func redactedFlagValue(f *flag.Flag) string {
+	dsns, ok := f.Value.(*dsnFlag)
+	if !ok {
+		return f.Value.String()
+	}
+	dialect, err := dal.ParseDialect(*dbDriver)
+	if err != nil {
+		return "<redacted>"
+	}
+	masked := make([]string, 0, len(*dsns))
+	for _, dsn := range *dsns {
+		masked = append(masked, dialect.MaskDSN(dsn))
+	}
+	return strings.Join(masked, ",")
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// 由 -filename-template 解析出的模板
+var filenameTemplate *template.Template
+
+// 文件名模板可使用的变量
+type filenameData struct {
+	Chain string // 清洗后的链名
+}
+
+// 解析并校验文件名模板，生成的文件名不能包含路径分隔符，也不能逃出输出目录
+func parseFilenameTemplate(text string) error {
+	tmpl, err := template.New("filename").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return fmt.Errorf("无效的 -filename-template %q: %v", text, err)
+	}
+	filenameTemplate = tmpl
+
+	name, err := renderFilename("example")
+	if err != nil {
+		return fmt.Errorf("无效的 -filename-template %q: %v", text, err)
+	}
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("-filename-template %q 生成的文件名 %q 不合法，不能包含路径分隔符", text, name)
+	}
+	if name == mustRenderFilename("other") {
+		return fmt.Errorf("-filename-template %q 必须使用 {{.Chain}} 区分不同链的文件", text)
+	}
+	return nil
+}
+
+// 按模板生成文件名
+func renderFilename(chain string) (string, error) {
+	var b strings.Builder
+	if err := filenameTemplate.Execute(&b, filenameData{Chain: chain}); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// 模板在启动时已经校验过，只有 .Chain 一个变量，执行不会失败
+func mustRenderFilename(chain string) string {
+	name, err := renderFilename(chain)
+	if err != nil {
+		panic(err)
+	}
+	return name
+}
+
+// 指定链对应的指标文件路径
+func chainFilePath(dir, chain string) string {
+	return filepath.Join(dir, mustRenderFilename(sanitizeMetricName(chain)))
+}
+
+// 匹配输出目录中所有由本工具按模板生成的文件，清洗后的链名不会包含 *
+func chainFileGlob(dir string) string {
+	return filepath.Join(dir, mustRenderFilename("*"))
+}
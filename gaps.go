@@ -0,0 +1,23 @@
+package main
+
+// 统计最近 window 个 epoch（以最大 epoch 结尾）中没有 share 的 epoch 个数。
+// 窗口不早于已记录的最小 epoch，新上线的链不会因为没有历史数据被算作缺失
+func missingEpochs(epochShares map[int64]int64, window int64) int64 {
+	if len(epochShares) == 0 || window <= 0 {
+		return 0
+	}
+
+	epochs := sortedEpochs(epochShares)
+	lo, hi := epochs[0], epochs[len(epochs)-1]
+	if start := hi - window + 1; start > lo {
+		lo = start
+	}
+
+	var present int64
+	for _, epoch := range epochs {
+		if epoch >= lo && epochShares[epoch] > 0 {
+			present++
+		}
+	}
+	return hi - lo + 1 - present
+}
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestMissingEpochs(t *testing.T) {
+	tests := []struct {
+		name   string
+		shares map[int64]int64
+		window int64
+		want   int64
+	}{
+		{"没有数据", map[int64]int64{}, 10, 0},
+		{"nil", nil, 10, 0},
+		{"窗口为 0", map[int64]int64{1: 1, 3: 1}, 0, 0},
+		{"连续", map[int64]int64{1: 5, 2: 5, 3: 5, 4: 5, 5: 5}, 5, 0},
+		// 窗口为 4..7，epoch 4 缺失
+		{"窗口开头缺失", map[int64]int64{1: 1, 2: 1, 5: 1, 6: 1, 7: 1}, 4, 1},
+		{"中间缺失", map[int64]int64{1: 1, 2: 1, 4: 1, 5: 1}, 5, 1},
+		// 最大的 epoch share 为 0
+		{"末尾缺失", map[int64]int64{1: 1, 2: 1, 3: 0}, 3, 1},
+		{"多处缺失", map[int64]int64{10: 1, 12: 0, 15: 1, 20: 1}, 11, 8},
+		// 窗口不早于最小的 epoch
+		{"窗口大于数据", map[int64]int64{3: 1, 5: 1}, 100, 1},
+		{"只有一个 epoch", map[int64]int64{7: 1}, 100, 0},
+		// 窗口之前的缺失不计入
+		{"缺失在窗口之前", map[int64]int64{1: 1, 5: 1, 6: 1, 7: 1}, 3, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := missingEpochs(tt.shares, tt.window); got != tt.want {
+				t.Errorf("missingEpochs(%v, %d) = %d，应为 %d", tt.shares, tt.window, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	graphiteAddr   = flag.String("graphite-addr", "", "Send every cycle's values in the plaintext protocol to this Graphite/carbon TCP address (e.g. carbon:2003), in addition to -mode")
+	graphitePrefix = flag.String("graphite-prefix", "oula.shares", "Metric path prefix of the values sent to -graphite-addr, followed by the chain and the value name")
+)
+
+func init() {
+	registerSink(newGraphiteSink)
+}
+
+// 保持一个 TCP 连接，写失败时关闭，下一次发送重新连接
+type graphiteSink struct {
+	addr   string
+	prefix string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newGraphiteSink() (sink, error) {
+	if *graphiteAddr == "" {
+		return nil, nil
+	}
+	if _, _, err := net.SplitHostPort(*graphiteAddr); err != nil {
+		return nil, fmt.Errorf("-graphite-addr %q 必须是 host:port 形式: %v", *graphiteAddr, err)
+	}
+	prefix := strings.Trim(*graphitePrefix, ".")
+	for _, segment := range strings.Split(prefix, ".") {
+		if prefix != "" && graphiteSegment(segment) != segment {
+			return nil, fmt.Errorf("-graphite-prefix %q 中的 %q 只能包含字母、数字、下划线和减号", *graphitePrefix, segment)
+		}
+	}
+	return &graphiteSink{addr: *graphiteAddr, prefix: prefix}, nil
+}
+
+func (s *graphiteSink) name() string {
+	return "graphite"
+}
+
+func (s *graphiteSink) send(ctx context.Context, data *cycleData) error {
+	body := graphiteLines(s.prefix, data)
+	if len(body) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reused := s.conn != nil
+	err := s.write(ctx, body)
+	if err != nil && reused {
+		// 复用的连接可能已经被服务端关闭，重新连接后再试一次
+		err = s.write(ctx, body)
+	}
+	return err
+}
+
+// 在 -sink-timeout 内写完 body，出错时关闭连接
+func (s *graphiteSink) write(ctx context.Context, body []byte) error {
+	if s.conn != nil && graphiteConnClosed(s.conn) {
+		s.conn.Close()
+		s.conn = nil
+	}
+	if s.conn == nil {
+		dialer := net.Dialer{Timeout: *sinkTimeout}
+		conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(*sinkTimeout))
+	if _, err := s.conn.Write(body); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// carbon 不会发送数据，连接上能读到 EOF 或其他错误说明服务端已经关闭了连接；
+// 不检查的话第一次写入通常仍然成功，数据会丢失。
+// 截止时间已经过去时 Read 不会真正读取，直接返回超时，所以留出 1ms
+func graphiteConnClosed(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	defer conn.SetReadDeadline(time.Time{})
+	var buf [1]byte
+	_, err := conn.Read(buf[:])
+	var netErr net.Error
+	return err != nil && !(errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// 按明文协议渲染每个链的值，例如 oula.shares.aleo.epoch_count 123 1700000000，时间戳是查询时间（秒）
+func graphiteLines(prefix string, data *cycleData) []byte {
+	var b bytes.Buffer
+	for _, chain := range data.chains {
+		s := data.stats[chain]
+		path := graphiteSegment(chain)
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+		ts := " " + strconv.FormatInt(s.UpdatedAt.Unix(), 10) + "\n"
+		b.WriteString(path + ".epoch_count " + strconv.FormatInt(s.EpochCount, 10) + ts)
+		if s.HasMaxEpoch {
+			b.WriteString(path + ".max_epoch " + strconv.FormatInt(s.MaxEpoch, 10) + ts)
+		}
+		if *exportNonzeroEpochs {
+			b.WriteString(path + ".nonzero_epochs " + strconv.Itoa(s.NonzeroEpochs) + ts)
+		}
+		if *exportMissingEpochs {
+			b.WriteString(path + ".missing_epochs " + strconv.FormatInt(s.MissingEpochs, 10) + ts)
+		}
+		if *exportDelta {
+			b.WriteString(path + ".delta " + strconv.FormatInt(s.Delta, 10) + ts)
+		}
+	}
+	return b.Bytes()
+}
+
+// 把链名转换为一个 Graphite 路径段：点、空格等字符替换为下划线
+func graphiteSegment(s string) string {
+	if s == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 在本地端口接收明文协议的 carbon，每个连接收到的行按连接分别发送到 conns
+type fakeCarbon struct {
+	listener net.Listener
+	conns    chan chan string
+}
+
+func newFakeCarbon(t *testing.T) *fakeCarbon {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	c := &fakeCarbon{listener: listener, conns: make(chan chan string, 10)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			lines := make(chan string, 100)
+			c.conns <- lines
+			go func() {
+				defer close(lines)
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					lines <- scanner.Text()
+				}
+			}()
+		}
+	}()
+	return c
+}
+
+// 等待下一个连接，从中读取 n 行
+func (c *fakeCarbon) readLines(t *testing.T, n int) []string {
+	t.Helper()
+	var lines chan string
+	select {
+	case lines = <-c.conns:
+	case <-time.After(5 * time.Second):
+		t.Fatal("没有收到连接")
+	}
+	return readFrom(t, lines, n)
+}
+
+func readFrom(t *testing.T, lines chan string, n int) []string {
+	t.Helper()
+	var result []string
+	for len(result) < n {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatalf("连接关闭，只收到 %d 行: %v", len(result), result)
+			}
+			result = append(result, line)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("只收到 %d 行: %v", len(result), result)
+		}
+	}
+	return result
+}
+
+func newTestGraphiteSink(t *testing.T, addr string) sink {
+	t.Helper()
+	setFlag(t, "graphite-addr", addr)
+	setFlag(t, "graphite-prefix", "oula.shares")
+	s, err := newGraphiteSink()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if conn := s.(*graphiteSink).conn; conn != nil {
+			conn.Close()
+		}
+	})
+	return s
+}
+
+func TestGraphiteSink(t *testing.T) {
+	setFlag(t, "export-delta", "true")
+	carbon := newFakeCarbon(t)
+	s := newTestGraphiteSink(t, carbon.listener.Addr().String())
+
+	now := time.Unix(1700000000, 0)
+	data := testCycleData(now, map[string]int64{"aleo": 1410, "quai.main": 97, "my chain/x": 5})
+	data.stats["aleo"].MaxEpoch, data.stats["aleo"].HasMaxEpoch = 103, true
+	data.stats["aleo"].Delta = 60
+
+	if err := s.send(context.Background(), data); err != nil {
+		t.Fatalf("发送失败: %v", err)
+	}
+	want := []string{
+		"oula.shares.aleo.epoch_count 1410 1700000000",
+		"oula.shares.aleo.max_epoch 103 1700000000",
+		"oula.shares.aleo.delta 60 1700000000",
+		"oula.shares.my_chain_x.epoch_count 5 1700000000",
+		"oula.shares.my_chain_x.delta 0 1700000000",
+		"oula.shares.quai_main.epoch_count 97 1700000000",
+		"oula.shares.quai_main.delta 0 1700000000",
+	}
+	got := carbon.readLines(t, len(want))
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("收到\n%s\n应为\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// 服务端关闭连接后，下一次发送重新连接，数据不会丢失
+func TestGraphiteSinkReconnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	s := newTestGraphiteSink(t, listener.Addr().String())
+	data := testCycleData(time.Unix(1700000000, 0), map[string]int64{"aleo": 1})
+
+	if err := s.send(context.Background(), data); err != nil {
+		t.Fatalf("第一次发送失败: %v", err)
+	}
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "oula.shares.aleo.epoch_count 1 1700000000\n" {
+		t.Fatalf("第一个连接收到 %q, %v", line, err)
+	}
+	conn.Close()
+	// 等待 FIN 到达客户端
+	time.Sleep(50 * time.Millisecond)
+
+	data.stats["aleo"].EpochCount = 2
+	if err := s.send(context.Background(), data); err != nil {
+		t.Fatalf("重新连接后发送失败: %v", err)
+	}
+	listener.(*net.TCPListener).SetDeadline(time.Now().Add(5 * time.Second))
+	conn, err = listener.Accept()
+	if err != nil {
+		t.Fatalf("没有重新连接: %v", err)
+	}
+	defer conn.Close()
+	line, err = bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "oula.shares.aleo.epoch_count 2 1700000000\n" {
+		t.Errorf("第二个连接收到 %q, %v", line, err)
+	}
+}
+
+func TestNewGraphiteSinkValidation(t *testing.T) {
+	tests := []struct {
+		addr, prefix string
+		wantErr      bool
+	}{
+		{"carbon:2003", "oula.shares", false},
+		{"carbon:2003", ".oula.shares.", false},
+		{"carbon:2003", "", false},
+		{"carbon", "oula.shares", true},
+		{"carbon:2003", "oula.sh ares", true},
+		{"carbon:2003", "oula..shares", true},
+	}
+	for _, tt := range tests {
+		setFlag(t, "graphite-addr", tt.addr)
+		setFlag(t, "graphite-prefix", tt.prefix)
+		if _, err := newGraphiteSink(); (err != nil) != tt.wantErr {
+			t.Errorf("-graphite-addr=%s -graphite-prefix=%q 返回 %v", tt.addr, tt.prefix, err)
+		}
+	}
+}
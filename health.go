@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var listenAddr = flag.String("listen-addr", "", "Serve /healthz, /readyz and /metrics on this address, e.g. :9109; empty disables the HTTP server")
+
+// 关闭 HTTP 服务时等待正在处理的请求的最长时间
+const httpShutdownTimeout = 5 * time.Second
+
+// 最近一轮的结果，供 /readyz 判断
+var (
+	healthMu       sync.Mutex
+	lastCycleAt    time.Time
+	lastCycleQuery bool // 是否至少有一个数据源查询成功
+)
+
+// 启动时是否正在重试第一次数据库连接
+var waitingForDB atomic.Bool
+
+// /readyz 使用的轮询间隔。cycleInterval 在 SIGHUP 时由主循环修改，HTTP 处理函数不能直接读取
+var readinessInterval atomic.Int64
+
+func setReadinessInterval(d time.Duration) {
+	readinessInterval.Store(int64(d))
+}
+
+// 每轮结束后记录结果
+func recordCycleHealth(finishedAt time.Time) {
+	queryOK := false
+	for _, src := range sources {
+		queryOK = queryOK || src.up
+	}
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	lastCycleAt = finishedAt
+	lastCycleQuery = queryOK
+}
+
+// /readyz 的响应内容，字段名属于对外约定
+type readiness struct {
+	Ready       bool   `json:"ready"`
+	Reason      string `json:"reason,omitempty"`
+	LastCycleAt int64  `json:"last_cycle_at,omitempty"`
+	QueryOK     bool   `json:"last_query_ok"`
+
+	// 每个链最近一次的写入或读取错误，不影响是否就绪
+	ChainErrors map[string]chainLastError `json:"chain_errors,omitempty"`
+}
+
+// 最近一轮在 2 倍轮询间隔之内完成并且数据库查询成功时就绪
+func checkReadiness(now time.Time) readiness {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
+	r := readiness{Ready: true, QueryOK: lastCycleQuery, ChainErrors: chainLastErrorsSnapshot()}
+	if !lastCycleAt.IsZero() {
+		r.LastCycleAt = lastCycleAt.Unix()
+	}
+	switch {
+	case waitingForDB.Load():
+		r.Ready, r.Reason = false, "waiting for the initial database connection"
+	case lastCycleAt.IsZero():
+		r.Ready, r.Reason = false, "no cycle has completed yet"
+	case now.Sub(lastCycleAt) > 2*time.Duration(readinessInterval.Load()):
+		r.Ready, r.Reason = false, "last cycle finished more than 2 intervals ago"
+	case !lastCycleQuery:
+		r.Ready, r.Reason = false, "last database query failed"
+	}
+	return r
+}
+
+func healthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		status := checkReadiness(time.Now())
+		w.Header().Set("Content-Type", "application/json")
+		if !status.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(status)
+	})
+	mux.Handle("/metrics", metricsHandler())
+	return mux
+}
+
+// 启动 -listen-addr 指定的 HTTP 服务，ctx 被取消时关闭；监听失败时直接返回错误
+func startHTTPServer(ctx context.Context) error {
+	if *listenAddr == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", *listenAddr)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: healthHandler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Error("HTTP 服务出错", "err", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	slog.Info("在 -listen-addr 提供 /healthz、/readyz 和 /metrics", "addr", listener.Addr().String())
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCheckReadiness(t *testing.T) {
+	setCycleInterval(t, time.Minute)
+	src := testSource("", nil)
+	setSources(t, src)
+	t.Cleanup(func() {
+		healthMu.Lock()
+		lastCycleAt, lastCycleQuery = time.Time{}, false
+		healthMu.Unlock()
+	})
+
+	now := time.Now()
+	src.up = true
+	recordCycleHealth(now)
+	if r := checkReadiness(now.Add(time.Minute)); !r.Ready {
+		t.Errorf("一轮之后应该就绪: %+v", r)
+	}
+	if r := checkReadiness(now.Add(3 * time.Minute)); r.Ready {
+		t.Errorf("超过 2 倍轮询间隔后不应就绪: %+v", r)
+	}
+	// SIGHUP 把间隔改长之后，同样的时间仍然就绪
+	setCycleInterval(t, 5*time.Minute)
+	if r := checkReadiness(now.Add(3 * time.Minute)); !r.Ready {
+		t.Errorf("轮询间隔变长后应该就绪: %+v", r)
+	}
+	src.up = false
+	recordCycleHealth(now)
+	if r := checkReadiness(now); r.Ready || r.QueryOK {
+		t.Errorf("查询失败后不应就绪: %+v", r)
+	}
+}
+
+// HTTP 服务与主循环同时访问状态，需要用 -race 运行才能发现问题
+func TestHealthHandlerConcurrentWithCycles(t *testing.T) {
+	setFlag(t, "listen-addr", "127.0.0.1:0")
+	setFlag(t, "poll-interval", "10s")
+	setCycleInterval(t, 10*time.Second)
+	src := testSource("", nil)
+	src.up = true
+	setSources(t, src)
+	server := httptest.NewServer(healthHandler())
+	defer server.Close()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, path := range []string{"/readyz", "/metrics", "/healthz"} {
+		path := path
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				resp, err := http.Get(server.URL + path)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+		}()
+	}
+
+	// 模拟主循环：每轮记录结果，期间收到 SIGHUP 修改轮询间隔
+	for i := 0; i < 500; i++ {
+		recordCycleHealth(time.Now())
+		recordCycleResult(nil)
+		recordWriteResult(nil)
+		publishSelfMetrics()
+		lastOutputAt.Store(time.Now().UnixNano())
+		interval := "10s"
+		if i%2 == 0 {
+			interval = "20s"
+		}
+		setFlag(t, "poll-interval", interval)
+		if err := resolvePollInterval(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	resp, err := http.Get(server.URL + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var r readiness
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || !r.Ready {
+		t.Errorf("/readyz 返回 %d %+v", resp.StatusCode, r)
+	}
+}
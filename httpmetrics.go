@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// 最近一轮结束时的自身指标，由主循环更新，/metrics 只读取快照，不需要与主循环加锁
+var selfMetricsSnapshot atomic.Pointer[[]*dto.MetricFamily]
+
+// 每轮结束后更新 /metrics 的快照，与输出方式无关；没有 -listen-addr 时不需要
+func publishSelfMetrics() {
+	if *listenAddr == "" {
+		return
+	}
+	families := metaFamilies(time.Now())
+	decorateFamilies(families)
+	snapshot := make([]*dto.MetricFamily, 0, len(families))
+	for _, family := range families {
+		if len(family.samples) == 0 {
+			continue
+		}
+		mf := family.toDTO()
+		// 抓取时间由 Prometheus 决定
+		for _, m := range mf.Metric {
+			m.TimestampMs = nil
+		}
+		snapshot = append(snapshot, mf)
+	}
+	selfMetricsSnapshot.Store(&snapshot)
+}
+
+// /metrics：工具自身的指标，加上 Go 运行时、进程和构建信息
+func metricsHandler() http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewBuildInfoCollector(),
+	)
+	self := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		if snapshot := selfMetricsSnapshot.Load(); snapshot != nil {
+			return *snapshot, nil
+		}
+		return nil, nil
+	})
+	return promhttp.HandlerFor(prometheus.Gatherers{registry, self}, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	influxURL         = flag.String("influx-url", "", "Write every cycle's values in line protocol to this InfluxDB 2.x server (e.g. http://influx:8086), in addition to -mode")
+	influxOrg         = flag.String("influx-org", "", "InfluxDB organization of -influx-bucket")
+	influxBucket      = flag.String("influx-bucket", "", "InfluxDB bucket written by -influx-url")
+	influxTokenFile   = flag.String("influx-token-file", "", "File containing the InfluxDB API token")
+	influxMeasurement = flag.String("influx-measurement", "oula_shares", "Measurement name of the points written to -influx-url")
+)
+
+func init() {
+	registerSink(newInfluxSink)
+}
+
+// 每个链一个点，所有链合并为一个请求写入 /api/v2/write
+type influxSink struct {
+	url    string
+	client *http.Client
+	token  string
+}
+
+func newInfluxSink() (sink, error) {
+	if *influxURL == "" {
+		return nil, nil
+	}
+	u, err := parseSinkURL("-influx-url", *influxURL)
+	if err != nil {
+		return nil, err
+	}
+	if *influxOrg == "" || *influxBucket == "" {
+		return nil, fmt.Errorf("-influx-url 需要同时指定 -influx-org 和 -influx-bucket")
+	}
+	if *influxMeasurement == "" {
+		return nil, fmt.Errorf("-influx-measurement 不能为空")
+	}
+	u = u.JoinPath("api", "v2", "write")
+	query := u.Query()
+	query.Set("org", *influxOrg)
+	query.Set("bucket", *influxBucket)
+	query.Set("precision", "ns")
+	u.RawQuery = query.Encode()
+
+	s := &influxSink{url: u.String()}
+	if *influxTokenFile != "" {
+		if s.token, err = readSecretFile("-influx-token-file", *influxTokenFile); err != nil {
+			return nil, err
+		}
+	}
+	if s.client, err = newSinkHTTPClient(""); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *influxSink) name() string {
+	return "influx"
+}
+
+func (s *influxSink) send(ctx context.Context, data *cycleData) error {
+	body := influxLines(data)
+	if len(body) == 0 {
+		return nil
+	}
+	return sendWithRetry(ctx, s.client, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		req.Header.Set("User-Agent", "oula-shares-push/"+version)
+		if s.token != "" {
+			req.Header.Set("Authorization", "Token "+s.token)
+		}
+		return req, nil
+	})
+}
+
+// 按行协议渲染每个链的值，例如 oula_shares,chain=aleo epoch_count=123i,max_epoch=456i 1700000000000000000。
+// 链名、数据源和 -label 作为 tag，时间戳是查询时间（纳秒）
+func influxLines(data *cycleData) []byte {
+	var b bytes.Buffer
+	static := extraLabels.pairs()
+	for _, chain := range data.chains {
+		s := data.stats[chain]
+		b.WriteString(influxEscape(*influxMeasurement, ", "))
+		tags := append([]labelPair{{"chain", s.chainName(chain)}}, static...)
+		if s.Source != "" {
+			tags = append(tags, labelPair{"source", s.Source})
+		}
+		// InfluxDB 建议按键排序 tag
+		sort.Slice(tags, func(i, j int) bool { return tags[i].name < tags[j].name })
+		for _, tag := range tags {
+			if tag.value == "" {
+				continue
+			}
+			b.WriteString("," + influxEscape(tag.name, ",= ") + "=" + influxEscape(tag.value, ",= "))
+		}
+
+		fields := []string{"epoch_count=" + strconv.FormatInt(s.EpochCount, 10) + "i"}
+		if s.HasMaxEpoch {
+			fields = append(fields, "max_epoch="+strconv.FormatInt(s.MaxEpoch, 10)+"i")
+		}
+		if *exportNonzeroEpochs {
+			fields = append(fields, "nonzero_epochs="+strconv.Itoa(s.NonzeroEpochs)+"i")
+		}
+		if *exportMissingEpochs {
+			fields = append(fields, "missing_epochs="+strconv.FormatInt(s.MissingEpochs, 10)+"i")
+		}
+		if *exportDelta {
+			fields = append(fields, "delta="+strconv.FormatInt(s.Delta, 10)+"i")
+		}
+		b.WriteString(" " + strings.Join(fields, ",") + " " + strconv.FormatInt(s.UpdatedAt.UnixNano(), 10) + "\n")
+	}
+	return b.Bytes()
+}
+
+// 行协议中 measurement 需要转义逗号和空格，tag 的键和值还需要转义等号
+func influxEscape(s, special string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r == '\\' || strings.ContainsRune(special, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
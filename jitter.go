@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	intervalJitterFlag = flag.String("interval-jitter", "", "Randomize every wait between cycles within ±this, a duration (e.g. 20s) or a percentage of the interval (e.g. 10%); must be less than half of the interval")
+	startupJitter      = flag.Bool("startup-jitter", false, "Delay the first cycle by a random fraction of the interval, so instances started together do not query at the same time")
+)
+
+// 解析后的 -interval-jitter
+var intervalJitter time.Duration
+
+// 解析 -interval-jitter，必须在确定轮询间隔之后调用；抖动必须小于间隔的一半，
+// 保证相邻两轮开始时间的间隔始终为正
+func parseIntervalJitter() error {
+	value := strings.TrimSpace(*intervalJitterFlag)
+	if value == "" {
+		intervalJitter = 0
+		return nil
+	}
+	if percent, ok := strings.CutSuffix(value, "%"); ok {
+		p, err := strconv.ParseFloat(percent, 64)
+		if err != nil || p < 0 {
+			return fmt.Errorf("-interval-jitter %q 不是合法的百分比", value)
+		}
+		intervalJitter = time.Duration(float64(cycleInterval) * p / 100)
+	} else {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return fmt.Errorf("-interval-jitter %q 不是合法的时长或百分比", value)
+		}
+		intervalJitter = d
+	}
+	if 2*intervalJitter >= cycleInterval {
+		return fmt.Errorf("-interval-jitter=%v 必须小于轮询间隔 %v 的一半", intervalJitter, cycleInterval)
+	}
+	return nil
+}
+
+// 每轮开始时间的随机偏移，在 ±-interval-jitter 内，math/rand 在每个进程中使用不同的种子
+func jitterOffset() time.Duration {
+	if intervalJitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(2*intervalJitter)+1)) - intervalJitter
+}
+
+// 第一轮之前的随机延迟，没有指定 -startup-jitter 时为 0
+func startupDelay() time.Duration {
+	if !*startupJitter {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(cycleInterval)))
+}
+
+// 等待 d，ctx 被取消时提前返回 false
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// JSON 输出的文档结构，字段名属于对外约定，修改需保持兼容
+type jsonDocument struct {
+	Timestamp int64                `json:"timestamp"`
+	Chains    map[string]jsonChain `json:"chains"`
+}
+
+type jsonChain struct {
+	EpochCount int64  `json:"epoch_count"`
+	MaxEpoch   *int64 `json:"max_epoch,omitempty"`
+}
+
+// 把本轮所有链的数据写入 -json-output 指定的文件
+func writeJSONOutput(filePath string, queriedAt time.Time, chains []string, stats map[string]*chainStats) error {
+	doc := jsonDocument{
+		Timestamp: queriedAt.Unix(),
+		Chains:    make(map[string]jsonChain, len(chains)),
+	}
+	for _, chain := range chains {
+		s := stats[chain]
+		c := jsonChain{EpochCount: s.EpochCount}
+		if s.HasMaxEpoch {
+			maxEpoch := s.MaxEpoch
+			c.MaxEpoch = &maxEpoch
+		}
+		doc.Chains[chain] = c
+	}
+
+	content, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化 JSON 时发生错误: %v", err)
+	}
+	content = append(content, '\n')
+
+	if *dryRun {
+		fmt.Printf("==> %s <==\n%s\n", filePath, content)
+		return nil
+	}
+	return writeFileAtomic(filePath, content)
+}
+
+// 删除上次异常退出时 -json-output 留下的临时文件，它不在 cleanupTempFiles 匹配的 .*.prom.tmp 范围内
+func cleanupJSONTempFile() {
+	if *jsonOutput == "" || *dryRun {
+		return
+	}
+	tmpPath := tempFilePath(*jsonOutput)
+	if err := os.Remove(tmpPath); err != nil {
+		if !os.IsNotExist(err) {
+			slog.Error("删除临时文件时出错", "file", tmpPath, "err", err)
+		}
+		return
+	}
+	slog.Info("已删除遗留的临时文件", "file", tmpPath)
+}
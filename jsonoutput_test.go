@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// JSON 输出的字段名属于对外约定，与 testdata/json_output.golden 逐字节比较
+func TestWriteJSONOutputGolden(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shares.json")
+	stats := map[string]*chainStats{
+		"aleo": {EpochCount: 1410, MaxEpoch: 103, HasMaxEpoch: true},
+		"quai": {EpochCount: 97, MaxEpoch: 502, HasMaxEpoch: true},
+		"kas":  {EpochCount: 0},
+	}
+	if err := writeJSONOutput(path, time.Unix(1700000000, 0), []string{"aleo", "kas", "quai"}, stats); err != nil {
+		t.Fatalf("写入 JSON 失败: %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(filepath.Join("testdata", "json_output.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("JSON 输出与 testdata/json_output.golden 不一致:\n%s", got)
+	}
+}
+
+func TestCleanupJSONTempFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "shares.json")
+	setFlag(t, "json-output", path)
+	for _, name := range []string{"shares.json", ".shares.json.tmp", ".other.json.tmp"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cleanupJSONTempFile()
+
+	if _, err := os.Stat(tempFilePath(path)); !os.IsNotExist(err) {
+		t.Errorf("JSON 的临时文件没有被删除: %v", err)
+	}
+	for _, name := range []string{"shares.json", ".other.json.tmp"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s 不应被删除: %v", name, err)
+		}
+	}
+}
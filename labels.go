@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// 可以重复指定的 key=value 标签参数，也支持逗号分隔的多个键值对
+type labelsFlag map[string]string
+
+func (l labelsFlag) String() string {
+	pairs := make([]string, 0, len(l))
+	for _, p := range l.pairs() {
+		pairs = append(pairs, p.name+"="+p.value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (l labelsFlag) Set(value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return fmt.Errorf("标签 %q 的格式应为 key=value", pair)
+		}
+		if !isValidLabelName(key) {
+			return fmt.Errorf("标签名 %q 不合法", key)
+		}
+		if _, ok := l[key]; ok {
+			return fmt.Errorf("标签 %q 重复指定", key)
+		}
+		l[key] = val
+	}
+	return nil
+}
+
+// 按标签名排序返回
+func (l labelsFlag) pairs() []labelPair {
+	pairs := make([]labelPair, 0, len(l))
+	for k, v := range l {
+		pairs = append(pairs, labelPair{k, v})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].name < pairs[j].name })
+	return pairs
+}
+
+// 本工具自己输出的标签，不允许被额外标签覆盖，否则同一个样本会有两个同名标签，
+// node_exporter 会拒绝整个文件。新增标签时需要加到这里，labels_test.go 会检查
+var reservedLabels = map[string]bool{
+	"chain":    true,
+	"commit":   true, // oula_shares_push_build_info
+	"epoch":    true,
+	"instance": true,
+	"job":      true,
+	"le":       true, // -push-histogram
+	"query":    true, // oula_shares_db_query_*
+	"sink":     true, // oula_shares_push_sink_errors_total
+	"source":   true,
+	"stage":    true, // oula_shares_push_chain_errors_total
+	"target":   true, // oula_shares_push_gateway_*
+	"user":     true,
+	"version":  true, // oula_shares_push_build_info
+	"window":   true,
+}
+
+// 检查 flagName 指定的标签没有使用 reserved 中的标签名
+func checkReservedLabels(flagName string, labels labelsFlag, reserved map[string]bool) error {
+	for _, p := range labels.pairs() {
+		if reserved[p.name] {
+			return fmt.Errorf("标签名 %q 由本工具保留，不能用于 %s", p.name, flagName)
+		}
+	}
+	return nil
+}
+
+// 判断是否是合法的 Prometheus 标签名，__ 开头的标签名保留给 Prometheus 内部使用
+func isValidLabelName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "__") {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckReservedLabels(t *testing.T) {
+	for _, name := range []string{"version", "commit", "stage", "query", "target", "sink", "chain", "source"} {
+		if err := checkReservedLabels("-label", labelsFlag{name: "x"}, reservedLabels); err == nil {
+			t.Errorf("-label %s=x 应该被拒绝", name)
+		}
+	}
+	if err := checkReservedLabels("-label", labelsFlag{"region": "eu"}, reservedLabels); err != nil {
+		t.Errorf("-label region=eu: %v", err)
+	}
+}
+
+type nopSink struct{}
+
+func (nopSink) name() string                                    { return "nop" }
+func (nopSink) send(ctx context.Context, data *cycleData) error { return nil }
+
+// 打开所有会增加标签的功能，检查输出的每个标签名都在 reservedLabels 中
+func TestReservedLabelsCoverEmittedLabels(t *testing.T) {
+	for name, value := range map[string]string{
+		"mode":                  modeBoth,
+		"expected-chains":       "aleo",
+		"per-epoch":             "true",
+		"per-user":              "true",
+		"export-delta":          "true",
+		"export-nonzero-epochs": "true",
+		"export-missing-epochs": "true",
+		"export-query-duration": "true",
+		"validate-output":       "true",
+	} {
+		setFlag(t, name, value)
+	}
+	oldWindows, oldSinks := recentWindows, sinks
+	recentWindows, sinks = []time.Duration{time.Hour}, []sink{nopSink{}}
+	t.Cleanup(func() { recentWindows, sinks = oldWindows, oldSinks })
+	setSources(t, testSource("east", nil), testSource("west", nil))
+
+	now := time.Now()
+	recordChainError("aleo", stageWrite, errors.New("test"))
+	recordPush("http://pushgateway:9091", nil)
+	timedQuery(context.Background(), "east", "share_counts", func() (int, error) { return 0, nil })
+	sinkErrorsMu.Lock()
+	sinkErrors["nop"] += 0
+	sinkErrorsMu.Unlock()
+
+	stats := map[string]*chainStats{"aleo": {
+		EpochCount:   1,
+		EpochShares:  map[int64]int64{1: 1},
+		HasMaxEpoch:  true,
+		UpdatedAt:    now,
+		Source:       "east",
+		UserShares:   map[string]int64{"alice": 1},
+		RecentShares: map[time.Duration]int64{time.Hour: 1},
+	}}
+	families := append(chainFamilies([]string{"aleo"}, stats), metaFamilies(now)...)
+
+	used := make(map[string]bool)
+	for _, family := range families {
+		for _, s := range family.samples {
+			for _, l := range s.labels {
+				used[l.name] = true
+			}
+		}
+	}
+	// histogram 推送时由 client_golang 加上 le
+	used["le"] = true
+	for name := range used {
+		if !reservedLabels[name] {
+			t.Errorf("输出的标签 %s 不在 reservedLabels 中", name)
+		}
+	}
+	for _, name := range []string{"chain", "source", "epoch", "user", "window", "stage", "query", "target", "sink", "version", "commit"} {
+		if !used[name] {
+			t.Errorf("测试没有覆盖标签 %s", name)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+)
+
+// -output-dir 中的锁文件，不以 .prom 结尾，node_exporter 不会读取
+const lockFileName = ".oula-shares-push.lock"
+
+var noLock = flag.Bool("no-lock", false, "Do not take the exclusive lock on -output-dir that prevents two instances from writing the same files")
+
+// 持有锁的文件，进程退出前关闭即释放
+var lockFile *os.File
+
+// 锁定 -output-dir，另一个实例持有锁时返回错误。使用 flock，崩溃的进程留下的锁文件不会妨碍下次启动
+func lockOutputDir(dir string) error {
+	if *noLock {
+		return nil
+	}
+	f, err := lockDir(filepath.Join(dir, lockFileName))
+	if err != nil {
+		return err
+	}
+	lockFile = f
+	return nil
+}
+
+// 重新加载修改了 -output-dir 时调用：锁定新目录成功后才释放原来目录的锁
+func relockOutputDir(dir string) error {
+	if *noLock {
+		return nil
+	}
+	f, err := lockDir(filepath.Join(dir, lockFileName))
+	if err != nil {
+		return err
+	}
+	unlockOutputDir()
+	lockFile = f
+	return nil
+}
+
+// 释放 -output-dir 的锁，锁文件本身留在目录中
+func unlockOutputDir() {
+	if lockFile == nil {
+		return
+	}
+	lockFile.Close()
+	lockFile = nil
+}
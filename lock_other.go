@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import (
+	"log/slog"
+	"os"
+)
+
+// 没有 flock 的平台上不加锁
+func lockDir(path string) (*os.File, error) {
+	slog.Warn("当前平台不支持锁定 -output-dir", "file", path)
+	return nil, nil
+}
@@ -0,0 +1,41 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 同一个进程中两个实例锁定同一个目录：flock 按打开的文件区分，第二个实例同样失败，第一个释放后才能成功
+func TestLockOutputDir(t *testing.T) {
+	dir := t.TempDir()
+	first, err := lockDir(filepath.Join(dir, lockFileName))
+	if err != nil {
+		t.Fatalf("第一个实例加锁失败: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, lockFileName))
+	if err != nil || strings.TrimSpace(string(content)) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("锁文件的内容为 %q, %v，应为本进程的 pid", content, err)
+	}
+
+	err = lockOutputDir(dir)
+	if err == nil || !strings.Contains(err.Error(), "另一个实例正在写入") {
+		t.Fatalf("第二个实例加锁返回 %v", err)
+	}
+	if lockFile != nil {
+		t.Error("加锁失败时不应保存锁文件")
+	}
+
+	first.Close()
+	if err := lockOutputDir(dir); err != nil {
+		t.Fatalf("第一个实例释放后加锁失败: %v", err)
+	}
+	unlockOutputDir()
+	if lockFile != nil {
+		t.Error("释放后锁文件没有清空")
+	}
+}
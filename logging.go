@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// -log-format 支持的格式
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+var (
+	logLevel  = flag.String("log-level", "info", "Minimum log level: debug, info, warn or error")
+	logFormat = flag.String("log-format", logFormatText, "Log format: text or json")
+)
+
+// 按 -log-level 和 -log-format 设置默认的 logger，日志写到 stderr
+func setupLogging() error {
+	var level slog.Level
+	switch strings.ToLower(*logLevel) {
+	case "debug":
+		level = slog.LevelDebug
+	case "info":
+		level = slog.LevelInfo
+	case "warn":
+		level = slog.LevelWarn
+	case "error":
+		level = slog.LevelError
+	default:
+		return fmt.Errorf("不支持的 -log-level %q，可选值为 debug、info、warn 或 error", *logLevel)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch *logFormat {
+	case logFormatText:
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	case logFormatJSON:
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("不支持的 -log-format %q，可选值为 %s 或 %s", *logFormat, logFormatText, logFormatJSON)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// 启动阶段无法继续运行：记录一条 error 日志后以非 0 退出码退出
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
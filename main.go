@@ -1,139 +1,471 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"oula-shares-push/dal"
+	"oula-shares-push/promth"
 )
 
+// 查询的源表，由 -table 和 -schema 决定，启动时校验
+var sourceTable dal.Table
+
+// -label 指定的额外标签
+var extraLabels = make(labelsFlag)
+
+func init() {
+	flag.Var(extraLabels, "label", "Extra key=value label added to every series, repeatable or comma separated (e.g. -label region=eu,env=prod)")
+	flag.Var(extraLabels, "labels", "Alias of -label")
+}
+
 var (
-	opsDSN    = flag.String("opsDsn", "", "MySQL DSN, e.g. user:password@tcp(host:3306)/ops_db")
-	interval  = flag.Int("interval", 5, "Check interval in minutes")
-	outputDir = flag.String("output-dir", "/opt/node-exporter/prom", "Directory to write Prometheus metric files")
+	dbDriver   = flag.String("db-driver", string(dal.MySQL), "Database driver: mysql, postgres or sqlite (the DSN is then a database file path, for local development)")
+	tableName  = flag.String("table", dal.DefaultTable.Name, "Source table of the share counts")
+	schemaName = flag.String("schema", "", "Database (schema) of -table, empty uses the database of the DSN")
+	colChain   = flag.String("col-chain", "chain", "Column of -table holding the chain name")
+	colEpoch   = flag.String("col-epoch", "epoch", "Column of -table holding the epoch")
+	colCount   = flag.String("col-count", "share_count", "Column of -table holding the share count")
+
+	// 每隔几分钟才查询一次，少量连接即可，连接在负载均衡的空闲超时之前回收
+	dbMaxOpenConns    = flag.Int("db-max-open-conns", 2, "Maximum number of open MySQL connections, 0 is unlimited")
+	dbMaxIdleConns    = flag.Int("db-max-idle-conns", 1, "Maximum number of idle MySQL connections")
+	dbConnMaxLifetime = flag.Duration("db-conn-max-lifetime", 5*time.Minute, "Close MySQL connections after this long, 0 keeps them forever")
+	dbConnMaxIdleTime = flag.Duration("db-conn-max-idle-time", 0, "Close MySQL connections idle for this long, 0 keeps them")
+
+	dbTLSCA         = flag.String("db-tls-ca", "", "CA bundle used to verify the MySQL server certificate")
+	dbTLSCert       = flag.String("db-tls-cert", "", "Client certificate for MySQL TLS")
+	dbTLSKey        = flag.String("db-tls-key", "", "Client key for MySQL TLS")
+	dbTLSSkipVerify = flag.Bool("db-tls-skip-verify", false, "Skip MySQL server certificate verification, last resort only")
+
+	maxRows       = flag.Int("max-rows", 1000000, "Skip the cycle when any query returns more rows than this, 0 disables the limit")
+	queryTimeout  = flag.Duration("query-timeout", 30*time.Second, "Timeout of the database queries of each cycle; the cycle is skipped when it expires")
+	minShareCount = flag.Int64("min-share-count", 0, "Ignore epochs with a share_count below this, e.g. testnet noise; 0 keeps every epoch")
+
+	mode           = flag.String("mode", modeFile, "Output mode: file, pushgateway or both")
+	interval       = flag.Int("interval", 5, "Deprecated: check interval in minutes, use -poll-interval")
+	pollInterval   = flag.Duration("poll-interval", 0, "Check interval, e.g. 30s or 2m30s; supersedes -interval")
+	outputDir      = flag.String("output-dir", "/opt/node-exporter/prom", "Directory to write Prometheus metric files")
+	allowRelative  = flag.Bool("allow-relative", false, "Allow a relative -output-dir")
+	followSymlinks = flag.Bool("follow-symlinks", false, "Allow -output-dir to be a symlink")
+	outputFormat   = flag.String("format", formatText, "Output format of generated files: text or openmetrics")
+	jsonOutput     = flag.String("json-output", "", "Also write every chain's values as a JSON document to this path (e.g. /path/shares.json)")
+	metaFile       = flag.String("meta-file", "oula_shares_push_meta.prom", "File under -output-dir for the exporter's own health metrics, empty disables")
+	filenameTmpl   = flag.String("filename-template", "{{.Chain}}_shares_count.prom", "Go text/template for per-chain file names, {{.Chain}} is the sanitized chain name")
+	combinedFile   = flag.String("combined-file", "", "Write all chains into this single file under -output-dir (e.g. shares.prom) instead of one file per chain")
+	metricPrefix   = flag.String("metric-prefix", "", "Prefix prepended to every metric name written by this tool")
+	metricName     = flag.String("metric-name", "oula_shares_epoch_count", "Metric name used for every chain, with the chain as a label")
+	metricHelp     = flag.String("metric-help", "Share count at the latest epoch of the chain", "HELP text written for the share count metric")
+
+	// 每个链文件中除 share count 外可选的指标
+	exportMaxEpoch      = flag.Bool("export-max-epoch", true, "Export "+maxEpochMetricName+" per chain")
+	exportNonzeroEpochs = flag.Bool("export-nonzero-epochs", false, "Export "+nonzeroMetricName+", the number of epochs with nonzero shares per chain within -epoch-lookback")
+	exportDelta         = flag.Bool("export-delta", false, "Export "+deltaMetricName+" and "+deltaSecondsName+", the share count increase since the previous successful cycle and the seconds between them")
+	exportMissingEpochs = flag.Bool("export-missing-epochs", false, "Export "+missingMetricName+", the number of epochs without shares in the last -missing-epochs-window epochs per chain")
+	missingEpochsWindow = flag.Int64("missing-epochs-window", 100, "Number of most recent epochs checked for gaps by -export-missing-epochs")
+	exportQueryDuration = flag.Bool("export-query-duration", false, "Export "+durationMetricName+", the duration of the share count query per chain")
+
+	// share count 在 epoch 结束前只增不减，声明为 counter 后可以使用 rate()
+	sharesAsCounter = flag.Bool("shares-as-counter", false, "Declare the share count families as counters instead of gauges; max epoch and timestamps stay gauges")
+
+	perEpoch           = flag.Bool("per-epoch", false, "Also export the share count of each recent epoch as a separate series")
+	perEpochMetricName = flag.String("per-epoch-metric-name", "oula_shares", "Metric name used for per-epoch share counts")
+	epochLookback      = flag.Int64("epoch-lookback", 1000, "Only query the latest N epochs of each chain for per-epoch data, 0 queries every epoch")
+	maxEpochs          = flag.Int("max-epochs", 10, "Number of most recent epochs per chain exported in -per-epoch mode")
+
+	// 用户数量可能很多，每个链最多导出 -top-users 个用户
+	perUser   = flag.Bool("per-user", false, "Also export "+userMetricName+" for the top users of each chain in its latest epoch, from -user-table")
+	userTable = flag.String("user-table", "shares_user_epoch_counts", "Source table of the per-user share counts, in the same schema as -table")
+	topUsers  = flag.Int("top-users", 10, "Number of users per chain exported in -per-user mode")
+
+	fileMode  = flag.String("file-mode", "0644", "Permission bits (octal) of generated .prom files")
+	fileUID   = flag.Int("file-uid", -1, "Owner uid of generated .prom files, -1 keeps the current user")
+	fileGID   = flag.Int("file-gid", -1, "Owner gid of generated .prom files, -1 keeps the current group")
+	fileGroup = flag.String("file-group", "", "Owner group name of generated .prom files, alternative to -file-gid")
+
+	// node_exporter 的 textfile collector 不接受带时间戳的样本，默认关闭
+	emitTimestamps = flag.Bool("emit-timestamps", false, "Append the database query time (milliseconds) to every sample line")
+	// 时间戳每轮都会变化，需要跳过未变化的文件时可以关闭
+	lastUpdateMetric = flag.Bool("last-update-metric", true, "Export "+lastUpdateMetricName+"; its value changes every cycle, so files are never skipped as unchanged while it is enabled")
+
+	dryRun            = flag.Bool("dry-run", false, "Run a single cycle and print the rendered files to stdout instead of writing them")
+	once              = flag.Bool("once", false, "Run a single cycle and exit, e.g. from a cron job; exits non-zero when the query or any output failed")
+	writeConcurrency  = flag.Int("write-concurrency", 4, "Maximum number of chain files written in parallel")
+	diskErrorCooldown = flag.Duration("disk-error-cooldown", 5*time.Minute, "Pause writing data files for this long after a disk full or read-only filesystem error")
+	forceWrite        = flag.Bool("force-write", false, "Rewrite files every cycle even when the content has not changed")
+	snapshotDir       = flag.String("snapshot-dir", "", "Copy each file into this directory with a timestamp suffix before replacing it, empty disables")
+	snapshotKeep      = flag.Int("snapshot-keep", 10, "Number of snapshots kept per file in -snapshot-dir, 0 keeps all")
+	validateOutput    = flag.Bool("validate-output", false, "Parse every written file back and restore the previous content if it is not valid exposition text")
+	fsync             = flag.Bool("fsync", false, "fsync each file and its directory after writing, so files survive power loss")
+	staleAfter        = flag.Int("stale-after", 3, "Remove a chain's .prom file after the chain is missing from this many consecutive query results, 0 disables")
+
+	// 兼容旧版本的指标命名方式，下个版本移除
+	legacyMetricNames = flag.Bool("legacy-metric-names", false, "Deprecated: use <chain>_shares_count as the metric name instead of -metric-name")
 )
 
 func main() {
 	// 解析命令行标志
 	flag.Parse()
+	if *showVersion {
+		fmt.Println(versionString())
+		return
+	}
+	// 环境变量和配置文件中的 -log-level 读取之前先按命令行设置，非法值留到下面统一报错
+	setupLogging()
+	if err := loadEnvFlags(); err != nil {
+		fatal("读取环境变量失败", "err", err)
+	}
+	if err := loadConfigFile(); err != nil {
+		fatal("读取配置文件失败", "file", *configFile, "err", err)
+	}
+	if err := setupLogging(); err != nil {
+		fatal("日志参数无效", "err", err)
+	}
+	slog.Info("启动 oula-shares-push", "version", version, "commit", orUnknown(commit), "build_date", orUnknown(buildDate))
+	if *printConfig {
+		if err := printEffectiveConfig(); err != nil {
+			fatal("输出配置失败", "err", err)
+		}
+		return
+	}
 
-	// 校验 DSN
-	if *opsDSN == "" {
-		log.Panicln("mysqlDSN is required.")
+	if *legacyMetricNames {
+		slog.Warn("-legacy-metric-names 已废弃，将在下个版本移除，请改用 -metric-name")
 	}
 
-	// 初始化数据库连接
-	db, err := initDB(*opsDSN)
-	if err != nil {
-		log.Panicln("无法连接到数据库:", err)
+	if err := resolvePollInterval(); err != nil {
+		fatal("轮询间隔无效", "err", err)
+	}
+	if err := parseIntervalJitter(); err != nil {
+		fatal("-interval-jitter 无效", "err", err)
+	}
+	if err := checkWriteInterval(); err != nil {
+		fatal("-write-interval 无效", "err", err)
+	}
+	if *once {
+		slog.Info("单次运行模式（-once），执行一轮后退出")
 	}
-	// main 函数退出前关闭数据库连接
-	defer db.Close()
 
-	// 定期检查并推送数据
-	for {
-		// 从数据库获取各个链的最新分享计数
-		shareCounts, err := getShareCounts(db)
-		if err != nil {
-			log.Println("获取 share counts 时发生错误:", err)
-			time.Sleep(time.Minute * time.Duration(*interval))
-			continue
-		}
-
-		// 推送每个链的最新分享计数
-		for chain, epochCount := range shareCounts {
-			// 构建文件路径
-			filePath := fmt.Sprintf("%s/%s_shares_count.prom", *outputDir, chain)
-			log.Printf("正在写入指标数据到 %s", filePath)
-
-			// 使用封装好的函数写文件
-			if err := writeToPromFile(filePath, chain, epochCount); err != nil {
-				log.Printf("写入文件 %s 时出错: %v", filePath, err)
-			} else {
-				log.Printf("成功写入到 %s", filePath)
-			}
-		}
+	if *outputFormat != formatText && *outputFormat != formatOpenMetrics {
+		fatal("不支持的 -format，可选值为 "+formatText+" 或 "+formatOpenMetrics, "format", *outputFormat)
+	}
+	if *outputFormat == formatOpenMetrics && *validateOutput {
+		fatal("-validate-output 只支持 -format=text")
+	}
+	if *outputFormat == formatOpenMetrics && *staleAfter > 0 {
+		slog.Warn("OpenMetrics 格式的文件不包含生成标记，-stale-after 不会删除这些文件")
+	}
 
-		// 等待下次轮询
-		time.Sleep(time.Minute * time.Duration(*interval))
+	// 前缀本身可以为空，非空时必须能作为指标名的开头
+	if *metricPrefix != "" && !isValidMetricName(*metricPrefix) {
+		fatal("-metric-prefix 包含指标名中不允许的字符", "metric_prefix", *metricPrefix)
+	}
+	if !isValidMetricName(*metricName) || !isValidMetricName(*perEpochMetricName) {
+		fatal("-metric-name 或 -per-epoch-metric-name 不是合法的指标名", "metric_name", *metricName, "per_epoch_metric_name", *perEpochMetricName)
 	}
-}
 
-// 初始化 MySQL 连接
-func initDB(DSN string) (*sql.DB, error) {
-	db, err := sql.Open("mysql", DSN)
+	if err := checkReservedLabels("-label", extraLabels, reservedLabels); err != nil {
+		fatal("-label 无效", "err", err)
+	}
+
+	// 查询的 epoch 范围必须覆盖缺失检查的窗口，否则窗口前面的 epoch 都会被算作缺失
+	if *exportMissingEpochs && *epochLookback > 0 && *epochLookback < *missingEpochsWindow {
+		fatal("-epoch-lookback 小于 -missing-epochs-window", "epoch_lookback", *epochLookback, "missing_epochs_window", *missingEpochsWindow)
+	}
+
+	if err := parseChainFilter(); err != nil {
+		fatal("链过滤参数无效", "err", err)
+	}
+	if err := parseRecentWindows(); err != nil {
+		fatal("-recent-windows 无效", "err", err)
+	}
+
+	if err := parseFilenameTemplate(*filenameTmpl); err != nil {
+		fatal("-filename-template 无效", "err", err)
+	}
+
+	if err := parseFilePermissions(); err != nil {
+		fatal("文件权限参数无效", "err", err)
+	}
+
+	// 校验 DSN
+	dialect, err := dal.ParseDialect(*dbDriver)
 	if err != nil {
-		return nil, err
+		fatal("-db-driver 无效", "err", err)
 	}
-	err = db.Ping()
+	sources, err = parseSources(dialect)
 	if err != nil {
-		return nil, err
+		fatal("数据源参数无效", "err", err)
+	}
+	// 旧的指标名中没有标签，无法区分数据源
+	if multiSource() && *legacyMetricNames {
+		fatal("-legacy-metric-names 不支持多个 -opsDsn")
 	}
-	return db, nil
-}
 
-// 获取每个链的最新分享计数
-func getShareCounts(db *sql.DB) (map[string]int64, error) {
-	rows, err := db.Query("SELECT chain, MAX(epoch) AS latest_epoch FROM shares_epoch_counts GROUP BY chain")
-	if err != nil {
-		return nil, err
+	dal.MaxRows = *maxRows
+	if *minShareCount < 0 {
+		fatal("-min-share-count 不能小于 0")
+	}
+	if *minShareCount > 0 {
+		slog.Info("忽略 share_count 较小的 epoch", "min_share_count", *minShareCount)
+	} else {
+		slog.Info("不按 share_count 过滤 epoch")
 	}
-	defer rows.Close()
 
-	shareCounts := make(map[string]int64)
+	// 表名会拼接进 SQL，只接受合法的标识符
+	sourceTable = dal.Table{
+		Dialect: dialect,
+		Schema:  *schemaName,
+		Name:    *tableName,
+		Columns: dal.Columns{Chain: *colChain, Epoch: *colEpoch, Count: *colCount},
+	}
+	if err := sourceTable.Validate(); err != nil {
+		fatal("源表参数无效", "err", err)
+	}
+	if *perUser {
+		if err := sourceUserTable().Validate(); err != nil {
+			fatal("-user-table 无效", "err", err)
+		}
+		if *topUsers <= 0 {
+			fatal("-top-users 必须大于 0")
+		}
+	}
 
-	for rows.Next() {
-		var chain string
-		var latestEpoch int64
-		if err := rows.Scan(&chain, &latestEpoch); err != nil {
-			return nil, err
+	// 校验所选输出方式需要的参数
+	var pusher promth.MetricPusher
+	var pushOpts []promth.Option
+	switch *mode {
+	case modeFile:
+	case modePushgateway, modeBoth:
+		if len(pushAddrs) == 0 {
+			fatal("所选 -mode 需要指定 -push-addr", "mode", *mode)
 		}
-		// 查询该链的最新高度的 share_count
-		count, err := getShareCountAtEpoch(db, chain, latestEpoch)
+		if *pushJob == "" {
+			fatal("所选 -mode 需要指定 -push-job", "mode", *mode)
+		}
+		for _, addr := range pushAddrs {
+			if err := validatePushAddr(addr); err != nil {
+				fatal("-push-addr 无效", "err", err)
+			}
+		}
+		pushOpts, err = pushOptions()
+		if err != nil {
+			fatal("推送参数无效", "err", err)
+		}
+		// 整个进程复用同一组 Client，dry-run 模式下不推送
+		pusher, err = newPusher(pushOpts)
 		if err != nil {
-			log.Printf("Error getting share count for chain %s at epoch %d: %v", chain, latestEpoch, err)
-			continue
+			fatal("创建 Pushgateway 客户端失败", "err", err)
+		}
+		if *dryRun {
+			pusher = dryRunPusher{}
+		}
+	default:
+		fatal("不支持的 -mode，可选值为 "+modeFile+"、"+modePushgateway+" 或 "+modeBoth, "mode", *mode)
+	}
+
+	if err := setupSinks(); err != nil {
+		fatal("输出参数无效", "err", err)
+	}
+
+	// 校验输出目录，清理历史版本遗留的多行 .prom 文件以及上次异常退出留下的临时文件，dry-run 模式下不碰磁盘
+	if fileModeEnabled() && !*dryRun {
+		if err := ensureOutputPath(*outputDir); err != nil {
+			fatal("-output-dir 不可用", "dir", *outputDir, "err", err)
+		}
+		// 加锁之后才能清理，否则可能删掉另一个实例正在写入的临时文件
+		if err := lockOutputDir(*outputDir); err != nil {
+			fatal("无法锁定 -output-dir", "dir", *outputDir, "err", err)
+		}
+		defer unlockOutputDir()
+		cleanupOversizedPromFiles(*outputDir)
+		cleanupTempFiles(*outputDir)
+	}
+	cleanupJSONTempFile()
+
+	// 收到 SIGINT 或 SIGTERM 时取消 ctx：正在执行的查询被取消，等待中的轮询立即结束
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	// SIGHUP 重新加载配置，只在两轮之间处理，等待期间连续收到的多个信号只处理一次
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	state := &runtimeState{pusher: pusher, pushOpts: pushOpts}
+
+	// 在连接数据库之前启动，等待数据库期间 /readyz 返回未就绪
+	if err := startHTTPServer(ctx); err != nil {
+		fatal("无法监听 -listen-addr", "addr", *listenAddr, "err", err)
+	}
+
+	// 初始化数据库连接，连接可能在运行中被重新建立，main 函数退出前关闭当前的连接
+	if err := connectSources(ctx); err != nil {
+		shutdown(state.pushOpts)
+		return
+	}
+	defer closeSources()
+
+	for _, src := range sources {
+		for _, conn := range []*dbConn{src.conn, src.fallback} {
+			if conn == nil || conn.db == nil {
+				continue
+			}
+			// 表不存在或缺少列时直接退出，而不是每轮都报 SQL 错误
+			checkCtx, cancel := context.WithTimeout(context.Background(), *queryTimeout)
+			err = dal.CheckTable(checkCtx, conn.db, sourceTable)
+			cancel()
+			if err != nil {
+				fatal("源表不可用", "db", conn.String(), "err", err)
+			}
+			checkCtx, cancel = context.WithTimeout(context.Background(), *queryTimeout)
+			checkRecentColumn(checkCtx, conn)
+			cancel()
+			// 按用户统计的表是可选的，不可用时只关闭这个功能
+			if *perUser {
+				checkCtx, cancel := context.WithTimeout(context.Background(), *queryTimeout)
+				err := dal.CheckUserTable(checkCtx, conn.db, sourceUserTable())
+				cancel()
+				if err != nil {
+					slog.Warn("-user-table 不可用，-per-user 已关闭", "db", conn.String(), "err", err)
+					*perUser = false
+				}
+			}
 		}
-		shareCounts[chain] = count
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, err
+	// 同时启动的多个实例错开第一轮查询
+	if delay := startupDelay(); delay > 0 && !*dryRun {
+		slog.Info("-startup-jitter: 延迟开始第一轮", "delay", delay.Round(time.Millisecond))
+		if !sleepContext(ctx, delay) {
+			shutdown(state.pushOpts)
+			return
+		}
+	}
+
+	startWatchdog()
+	defer startWriteTicker()()
+	if *once {
+		notifyReady()
 	}
 
-	return shareCounts, nil
+	pollLoop(ctx, hup, state)
 }
 
-// 获取指定链在指定 epoch 高度的 share_count
-func getShareCountAtEpoch(db *sql.DB, chain string, epoch int64) (int64, error) {
-	var shareCount int64
-	err := db.QueryRow("SELECT share_count FROM shares_epoch_counts WHERE chain = ? AND epoch = ?", chain, epoch).Scan(&shareCount)
-	if err != nil {
-		return 0, err
+// 轮询间隔的下限，避免误配置成毫秒级时压垮数据库
+const minPollInterval = 5 * time.Second
+
+// 生效的轮询间隔，由 -poll-interval 或旧的 -interval 决定
+var cycleInterval time.Duration
+
+// 确定轮询间隔：-poll-interval 优先，否则使用以分钟为单位的 -interval
+func resolvePollInterval() error {
+	intervalSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "interval" {
+			intervalSet = true
+		}
+	})
+
+	switch {
+	case *pollInterval != 0:
+		cycleInterval = *pollInterval
+		if intervalSet {
+			slog.Warn("同时指定了 -interval 和 -poll-interval，使用 -poll-interval", "poll_interval", cycleInterval)
+		}
+	default:
+		if intervalSet {
+			slog.Warn("-interval 已废弃，将在下个版本移除，请改用 -poll-interval")
+		}
+		cycleInterval = time.Minute * time.Duration(*interval)
+	}
+
+	if cycleInterval < minPollInterval {
+		return fmt.Errorf("轮询间隔 %v 小于下限 %v", cycleInterval, minPollInterval)
 	}
-	return shareCount, nil
+	setReadinessInterval(cycleInterval)
+	slog.Info("轮询间隔", "interval", cycleInterval)
+	return nil
 }
 
-// 封装好的函数，用于写入 Prometheus 格式的数据到文件
-func writeToPromFile(filePath, chain string, epochCount int64) error {
-	file, err := os.OpenFile(filePath, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return fmt.Errorf("无法打开文件 %s: %v", filePath, err)
+// 定期检查并推送数据，每轮按固定节奏开始，ctx 被取消后清理并返回；dry-run 和 -once 只执行一轮
+func pollLoop(ctx context.Context, hup <-chan os.Signal, state *runtimeState) {
+	sched := newSchedule(time.Now())
+	for {
+		errs := runCycle(ctx, sources, state.pusher)
+		recordCycleHealth(time.Now())
+		// 被打断的一轮不更新元数据文件
+		if ctx.Err() != nil {
+			break
+		}
+		recordDiskErrors(errs)
+		recordCycleResult(errs)
+		publishSelfMetrics()
+		if fileModeEnabled() {
+			writeMetaFile()
+		}
+		notifySystemd("WATCHDOG=1")
+		if lastCycleSucceeded.Load() {
+			notifyReady()
+		}
+
+		// dry-run 和 -once 只执行一轮，分组留在 Pushgateway 中供下次运行前查看
+		if *dryRun || *once {
+			closeSources()
+			if len(errs) > 0 {
+				if *once {
+					slog.Error("单次运行失败", "errors", len(errs))
+				}
+				os.Exit(1)
+			}
+			if *once {
+				slog.Info("单次运行完成")
+			}
+			return
+		}
+
+		checkSourcesHealth(ctx)
+
+		// 等待下次轮询
+		if !waitNextCycle(ctx, sched.advance(time.Now()), hup, state) {
+			break
+		}
 	}
-	defer file.Close()
 
-	_, err = fmt.Fprintf(
-		file,
-		"%s_shares_count{instance=\"jumperserver\",job=\"%s\"} %d\n",
-		chain, chain, epochCount,
-	)
-	if err != nil {
-		return fmt.Errorf("写入文件 %s 时发生错误: %v", filePath, err)
+	shutdown(state.pushOpts)
+}
+
+// 收到退出信号后的清理：删除推送过的分组，清理被打断的写入留下的临时文件，数据库连接由 defer 关闭
+func shutdown(pushOpts []promth.Option) {
+	slog.Info("收到退出信号，正在退出")
+	notifySystemd("STOPPING=1")
+	deletePushedOnShutdown(pushOpts)
+	if fileModeEnabled() {
+		cleanupTempFiles(*outputDir)
 	}
+	cleanupJSONTempFile()
+}
 
-	return nil
+// 初始化数据库连接，驱动和连接池参数来自命令行
+func initDB(DSN string) (*sql.DB, error) {
+	return dal.InitDB(sourceTable.Dialect, DSN, dal.PoolConfig{
+		MaxOpenConns:    *dbMaxOpenConns,
+		MaxIdleConns:    *dbMaxIdleConns,
+		ConnMaxLifetime: *dbConnMaxLifetime,
+		ConnMaxIdleTime: *dbConnMaxIdleTime,
+	})
+}
+
+// 按用户统计的表，与 -table 使用同一个库和同样的列名
+func sourceUserTable() dal.Table {
+	return dal.Table{Dialect: sourceTable.Dialect, Schema: sourceTable.Schema, Name: *userTable, Columns: sourceTable.Columns}
+}
+
+// -db-tls-* 参数指定的证书
+func dbTLSFiles() dal.TLSFiles {
+	return dal.TLSFiles{CA: *dbTLSCA, Cert: *dbTLSCert, Key: *dbTLSKey, SkipVerify: *dbTLSSkipVerify}
 }
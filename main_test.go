@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"oula-shares-push/dal"
+)
+
+// 测试中不输出程序自身的日志，并完成 main 中启动时的初始化
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := parseFilenameTemplate(*filenameTmpl); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// 在测试期间修改参数，测试结束后恢复原来的值
+func setFlag(t testing.TB, name, value string) {
+	t.Helper()
+	f := flag.Lookup(name)
+	if f == nil {
+		t.Fatalf("参数 -%s 不存在", name)
+	}
+	old := f.Value.String()
+	resetFlag(name)
+	if err := f.Value.Set(value); err != nil {
+		t.Fatalf("设置 -%s=%s: %v", name, value, err)
+	}
+	t.Cleanup(func() {
+		resetFlag(name)
+		// 可以重复指定的参数清空后为空值，不能再设置空字符串
+		if old != "" || !repeatableFlag(name) {
+			f.Value.Set(old)
+		}
+	})
+}
+
+// 在测试期间修改 cycleInterval
+func setCycleInterval(t *testing.T, d time.Duration) {
+	t.Helper()
+	old := cycleInterval
+	cycleInterval = d
+	setReadinessInterval(d)
+	t.Cleanup(func() {
+		cycleInterval = old
+		setReadinessInterval(old)
+	})
+}
+
+// 一轮查询结果，每个链的 share count 由 counts 给出
+func testCycleData(now time.Time, counts map[string]int64) *cycleData {
+	data := &cycleData{stats: make(map[string]*chainStats), queriedAt: now}
+	for chain, count := range counts {
+		data.stats[chain] = &chainStats{EpochCount: count, UpdatedAt: now}
+	}
+	data.chains = sortedKeys(data.stats)
+	return data
+}
+
+// 在测试期间使用 srcs 作为所有数据源，每个数据源直接查询给出的 store。
+// 运行时数据源不会变化，记录的查询耗时同样清空，否则 source 标签前后不一致
+func setSources(t *testing.T, srcs ...*source) {
+	t.Helper()
+	old := sources
+	selfStatsMu.Lock()
+	oldDurations, oldErrors := queryDurations, queryErrors
+	queryDurations, queryErrors = make(map[queryKey]time.Duration), make(map[queryKey]int)
+	selfStatsMu.Unlock()
+	sources = srcs
+	t.Cleanup(func() {
+		sources = old
+		selfStatsMu.Lock()
+		queryDurations, queryErrors = oldDurations, oldErrors
+		selfStatsMu.Unlock()
+	})
+}
+
+func testSource(name string, store dal.ShareStore) *source {
+	return &source{name: name, conn: &dbConn{name: name, store: store}}
+}
+
+// 在两轮之间取消 ctx，轮询立即结束并清理被打断的写入留下的临时文件
+func TestPollLoopShutdown(t *testing.T) {
+	enableAllQueries(t)
+	dir := t.TempDir()
+	setFlag(t, "mode", modeFile)
+	setFlag(t, "output-dir", dir)
+	setCycleInterval(t, time.Hour)
+	oldCached := cachedData
+	t.Cleanup(func() { cachedData = oldCached })
+
+	src := testSource("", newFakeStore())
+	// 不会建立连接，查询成功时轮询结束后也不会 ping
+	db, err := sql.Open("mysql", "user:pw@tcp(127.0.0.1:1)/shares")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	src.conn.db = db
+	setSources(t, src)
+
+	// 上次运行时写到一半的文件，链不在本轮的结果中
+	stale := filepath.Join(dir, ".eth.prom.tmp")
+	if err := os.WriteFile(stale, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		pollLoop(ctx, make(chan os.Signal), &runtimeState{})
+		close(done)
+	}()
+
+	// 第一轮写入文件后进入一小时的等待
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(chainFilePath(dir, "aleo")); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("第一轮没有写入文件")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := os.Stat(stale); err != nil {
+		t.Fatalf("轮询期间删除了临时文件: %v", err)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("取消 ctx 后轮询没有结束")
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("退出时没有删除临时文件: %v", err)
+	}
+	if _, err := os.Stat(chainFilePath(dir, "aleo")); err != nil {
+		t.Errorf("退出时删除了输出文件: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"log/slog"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	pushSuccessMetricName = "oula_shares_push_success"
+	pushErrorsMetricName  = "oula_shares_push_errors_total"
+	validationMetricName  = "oula_shares_push_validation_failures_total"
+	rowLimitMetricName    = "oula_shares_push_row_limit_exceeded"
+	cyclesMetricName      = "oula_shares_cycles_total"
+)
+
+// 进程生命周期内累计的轮次和错误次数，以及最近一轮是否成功。
+// 由主循环更新，sink 和 HTTP 服务在其他 goroutine 中读取
+var (
+	pushErrorsTotal    atomic.Int64
+	cyclesTotal        atomic.Int64
+	lastCycleSucceeded atomic.Bool
+)
+
+// 每轮结束后记录结果，与输出方式无关
+func recordCycleResult(errs []error) {
+	cyclesTotal.Add(1)
+	pushErrorsTotal.Add(int64(len(errs)))
+	lastCycleSucceeded.Store(len(errs) == 0)
+}
+
+// -write-interval 重新输出缓存结果后记录结果：错误同样累计，写入失败时本轮视为失败，
+// 只有查询成功的一轮才能恢复
+func recordWriteResult(errs []error) {
+	pushErrorsTotal.Add(int64(len(errs)))
+	if len(errs) > 0 {
+		lastCycleSucceeded.Store(false)
+	}
+}
+
+// 元数据指标文件的路径
+func metaFilePath() string {
+	return filepath.Join(*outputDir, *metaFile)
+}
+
+// 写入本轮的成功状态和累计错误数，查询失败时同样会更新；
+// 与数据文件一样，磁盘错误后的冷却期内不写入
+func writeMetaFile() {
+	if *metaFile == "" || inDiskCooldown() {
+		return
+	}
+	filePath := metaFilePath()
+	if err := writeFamilies(filePath, metaFamilies(time.Now())); err != nil {
+		slog.Error("写入文件时出错", "file", filePath, "err", err)
+	}
+}
+
+// 工具自身的所有指标，写入元数据文件，也通过 -listen-addr 的 /metrics 提供
+func metaFamilies(now time.Time) []metricFamily {
+	successFamily := metricFamily{name: pushSuccessMetricName, help: "Whether the last cycle queried the database and wrote every file successfully"}
+	successFamily.addSample(nil, float64(btoi(lastCycleSucceeded.Load())), now)
+	cyclesFamily := metricFamily{name: cyclesMetricName, help: "Total number of cycles run since the process started", counter: true}
+	cyclesFamily.addSample(nil, float64(cyclesTotal.Load()), now)
+	errorsFamily := metricFamily{name: pushErrorsMetricName, help: "Total number of errors since the process started", counter: true}
+	errorsFamily.addSample(nil, float64(pushErrorsTotal.Load()), now)
+	diskErrorsFamily := metricFamily{name: diskErrorsMetricName, help: "Total number of writes that failed because the disk was full or read-only", counter: true}
+	diskErrorsFamily.addSample(nil, float64(diskErrorCount()), now)
+	families := []metricFamily{successFamily, cyclesFamily, errorsFamily, diskErrorsFamily, buildInfoFamily(now)}
+	families = append(families, selfFamilies(now)...)
+	if chainErrors := chainErrorsFamily(now); len(chainErrors.samples) > 0 {
+		families = append(families, chainErrors)
+	}
+	if *maxRows > 0 {
+		rowLimitFamily := metricFamily{name: rowLimitMetricName, help: "Whether a query of the last cycle returned more rows than -max-rows and the cycle was skipped"}
+		rowLimitFamily.addSample(nil, float64(btoi(rowLimitExceeded.Load())), now)
+		families = append(families, rowLimitFamily)
+	}
+
+	if *validateOutput {
+		validationFamily := metricFamily{name: validationMetricName, help: "Total number of written files that failed to parse and were rolled back", counter: true}
+		validationFamily.addSample(nil, float64(validationFailuresTotal.Load()), now)
+		families = append(families, validationFamily)
+	}
+
+	if len(sinks) > 0 {
+		families = append(families, sinkErrorsFamily(now))
+	}
+
+	// -mode=both 时推送路径的健康状况由 textfile 采集
+	if pushModeEnabled() {
+		families = append(families, pushStatsFamilies(now)...)
+	}
+	return families
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// 磁盘错误后的冷却期内不写入元数据文件，冷却结束后恢复
+func TestWriteMetaFileDiskCooldown(t *testing.T) {
+	setFlag(t, "output-dir", t.TempDir())
+	t.Cleanup(func() {
+		diskMu.Lock()
+		diskCooldownUntil = time.Time{}
+		diskMu.Unlock()
+	})
+
+	diskMu.Lock()
+	diskCooldownUntil = time.Now().Add(time.Minute)
+	diskMu.Unlock()
+	writeMetaFile()
+	if _, err := os.Stat(metaFilePath()); !os.IsNotExist(err) {
+		t.Errorf("冷却期内写入了元数据文件: %v", err)
+	}
+
+	diskMu.Lock()
+	diskCooldownUntil = time.Now().Add(-time.Second)
+	diskMu.Unlock()
+	writeMetaFile()
+	if _, err := os.Stat(metaFilePath()); err != nil {
+		t.Errorf("冷却结束后没有写入元数据文件: %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// 明显不应该写入指标文件的系统目录
+var dangerousOutputDirs = map[string]bool{
+	"/":     true,
+	"/bin":  true,
+	"/boot": true,
+	"/dev":  true,
+	"/etc":  true,
+	"/lib":  true,
+	"/proc": true,
+	"/root": true,
+	"/sbin": true,
+	"/sys":  true,
+	"/usr":  true,
+	"/var":  true,
+}
+
+// 启动时校验输出目录：拒绝危险路径和相对路径，默认不跟随符号链接，必须是已存在且可写的目录
+func ensureOutputPath(dir string) error {
+	if dir == "" {
+		return fmt.Errorf("-output-dir 不能为空")
+	}
+	if !filepath.IsAbs(dir) && !*allowRelative {
+		return fmt.Errorf("-output-dir %q 是相对路径，如确需使用请加上 -allow-relative", dir)
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("无法解析 -output-dir %q 的绝对路径: %v", dir, err)
+	}
+	if dangerousOutputDirs[abs] {
+		return fmt.Errorf("-output-dir %q 指向系统目录 %s，拒绝写入", dir, abs)
+	}
+
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("无法访问 -output-dir %q: %v", dir, err)
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		if !*followSymlinks {
+			return fmt.Errorf("-output-dir %q 是符号链接，如确需使用请加上 -follow-symlinks", dir)
+		}
+		target, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return fmt.Errorf("无法解析符号链接 -output-dir %q: %v", dir, err)
+		}
+		if dangerousOutputDirs[target] {
+			return fmt.Errorf("-output-dir %q 指向系统目录 %s，拒绝写入", dir, target)
+		}
+		if info, err = os.Stat(dir); err != nil {
+			return fmt.Errorf("无法访问 -output-dir %q: %v", dir, err)
+		}
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("-output-dir %q 不是目录", dir)
+	}
+	return probeWritable(dir)
+}
+
+// 写权限探测文件，以 . 开头不会被 textfile collector 读取
+const probeFileName = ".oula-shares-push-probe"
+
+// 在目录中创建并删除一个探测文件，确认当前用户确实可以写入
+func probeWritable(dir string) error {
+	probePath := filepath.Join(dir, probeFileName)
+	file, err := os.OpenFile(probePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, promFileMode)
+	if err != nil {
+		return fmt.Errorf("-output-dir %q 不可写: %v", dir, err)
+	}
+	file.Close()
+	if err := os.Remove(probePath); err != nil {
+		return fmt.Errorf("无法删除 -output-dir %q 中的探测文件 %s: %v", dir, probePath, err)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 目录存在但不可写时启动检查失败，不留下探测文件
+func TestProbeWritableReadOnly(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root 可以写入只读目录")
+	}
+	dir := t.TempDir()
+	if err := probeWritable(dir); err != nil {
+		t.Fatalf("可写的目录探测失败: %v", err)
+	}
+	if err := os.Chmod(dir, 0555); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(dir, 0755) })
+
+	err := probeWritable(dir)
+	if err == nil || !strings.Contains(err.Error(), "不可写") {
+		t.Errorf("只读目录探测返回 %v", err)
+	}
+	if err := ensureOutputPath(dir); err == nil {
+		t.Error("ensureOutputPath 接受了只读目录")
+	}
+	if _, err := os.Stat(filepath.Join(dir, probeFileName)); !os.IsNotExist(err) {
+		t.Errorf("留下了探测文件: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+)
+
+// 生成文件的权限和属主，由 -file-mode/-file-uid/-file-gid/-file-group 解析而来
+var (
+	promFileMode os.FileMode = 0644
+	promFileUID              = -1
+	promFileGID              = -1
+
+	chownWarnOnce sync.Once
+)
+
+// 解析文件权限相关的命令行参数
+func parseFilePermissions() error {
+	mode, err := strconv.ParseUint(*fileMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return fmt.Errorf("无效的 -file-mode %q", *fileMode)
+	}
+	promFileMode = os.FileMode(mode)
+	promFileUID = *fileUID
+	promFileGID = *fileGID
+
+	if *fileGroup != "" {
+		if *fileGID != -1 {
+			return fmt.Errorf("-file-gid 和 -file-group 不能同时指定")
+		}
+		group, err := user.LookupGroup(*fileGroup)
+		if err != nil {
+			return fmt.Errorf("无法找到用户组 %s: %v", *fileGroup, err)
+		}
+		gid, err := strconv.Atoi(group.Gid)
+		if err != nil {
+			return fmt.Errorf("用户组 %s 的 gid %q 无效: %v", *fileGroup, group.Gid, err)
+		}
+		promFileGID = gid
+	}
+	return nil
+}
+
+// 给新创建的文件设置权限和属主，chown 失败只记录一次日志，不影响写入
+func applyFilePermissions(file *os.File) error {
+	// 显式 chmod，避免受 umask 影响
+	if err := file.Chmod(promFileMode); err != nil {
+		return fmt.Errorf("设置文件 %s 权限时发生错误: %v", file.Name(), err)
+	}
+
+	if promFileUID == -1 && promFileGID == -1 {
+		return nil
+	}
+	if err := file.Chown(promFileUID, promFileGID); err != nil {
+		chownWarnOnce.Do(func() {
+			slog.Warn("无法修改文件属主（可能权限不足），后续不再提示", "uid", promFileUID, "gid", promFileGID, "err", err)
+		})
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 写入的文件使用 -file-mode 指定的权限，不受 umask 影响
+func TestApplyFilePermissions(t *testing.T) {
+	oldMode, oldUID, oldGID := promFileMode, promFileUID, promFileGID
+	t.Cleanup(func() { promFileMode, promFileUID, promFileGID = oldMode, oldUID, oldGID })
+	dir := t.TempDir()
+
+	for _, tt := range []struct {
+		flag string
+		want os.FileMode
+	}{
+		{"0644", 0644},
+		{"600", 0600},
+		// 常见的 umask 022 会去掉组写权限
+		{"0664", 0664},
+		{"0444", 0444},
+	} {
+		setFlag(t, "file-mode", tt.flag)
+		if err := parseFilePermissions(); err != nil {
+			t.Fatalf("-file-mode=%s: %v", tt.flag, err)
+		}
+		path := filepath.Join(dir, "mode_"+tt.flag+".prom")
+		if err := writeFileAtomic(path, []byte("oula_shares_epoch_count 1\n")); err != nil {
+			t.Fatalf("-file-mode=%s 写入失败: %v", tt.flag, err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got != tt.want {
+			t.Errorf("-file-mode=%s 写入的文件权限为 %o，应为 %o", tt.flag, got, tt.want)
+		}
+	}
+
+	for _, invalid := range []string{"0999", "1777", "rw-r--r--", ""} {
+		setFlag(t, "file-mode", invalid)
+		if err := parseFilePermissions(); err == nil {
+			t.Errorf("-file-mode=%q 应该返回错误", invalid)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package promth
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 给每个推送请求加上固定的请求头，交给 WithHTTPClient 使用的客户端作为 Transport
+func HeaderTransport(next http.RoundTripper, headers http.Header) http.RoundTripper {
+	return &headerTransport{next: next, headers: headers.Clone()}
+}
+
+type headerTransport struct {
+	next    http.RoundTripper
+	headers http.Header
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTripper 不能修改传入的请求
+	req = req.Clone(req.Context())
+	for name, values := range t.headers {
+		req.Header[name] = values
+	}
+	return t.next.RoundTrip(req)
+}
+
+// 把 path 文件的内容作为 Bearer token 加到每个推送请求上。
+// 文件的修改时间或大小变化后重新读取，轮换 token 不需要重启；token 的内容不会出现在错误信息中
+func BearerTokenFileTransport(next http.RoundTripper, path string) (http.RoundTripper, error) {
+	t := &tokenFileTransport{next: next, path: path}
+	if _, err := t.currentToken(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+type tokenFileTransport struct {
+	next http.RoundTripper
+	path string
+
+	mu      sync.Mutex
+	token   string
+	modTime time.Time
+	size    int64
+}
+
+func (t *tokenFileTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.currentToken()
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return t.next.RoundTrip(req)
+}
+
+// 返回当前的 token，文件没有变化时使用缓存
+func (t *tokenFileTransport) currentToken() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	info, err := os.Stat(t.path)
+	if err != nil {
+		return "", fmt.Errorf("读取 token 文件 %s 失败: %v", t.path, err)
+	}
+	if t.token != "" && info.ModTime().Equal(t.modTime) && info.Size() == t.size {
+		return t.token, nil
+	}
+
+	content, err := os.ReadFile(t.path)
+	if err != nil {
+		return "", fmt.Errorf("读取 token 文件 %s 失败: %v", t.path, err)
+	}
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return "", fmt.Errorf("token 文件 %s 为空", t.path)
+	}
+	t.token, t.modTime, t.size = token, info.ModTime(), info.Size()
+	return token, nil
+}
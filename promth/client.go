@@ -0,0 +1,198 @@
+package promth
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// 推送到同一个分组的客户端，启动时创建一次，之后每轮复用同一个 Pusher 和 registry
+type Client struct {
+	addr     string
+	job      string
+	grouping map[string]string
+	o        options
+
+	registry *prometheus.Registry
+	recorder *statusRecorder
+	pusher   *push.Pusher
+
+	mu       sync.Mutex
+	families map[string]*clientFamily
+}
+
+// 一个指标名对应的 collector，同名指标的类型和标签名必须一致
+type clientFamily struct {
+	typ        MetricType
+	labelNames []string
+	gauge      *prometheus.GaugeVec
+	counter    *prometheus.CounterVec
+	histogram  *prometheus.HistogramVec
+}
+
+// 按 WithGrouping 指定的分组创建客户端，分组标签不合法时返回错误
+func NewClient(addr, job string, opts ...Option) (*Client, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	// 每个 Client 各自限速
+	if o.maxRPS > 0 {
+		o.limiter = newLimiter(o.maxRPS)
+	}
+
+	pusher, err := newPusher(addr, job, o.grouping, o)
+	if err != nil {
+		return nil, err
+	}
+	registry := prometheus.NewRegistry()
+	recorder := &statusRecorder{doer: o.httpClient()}
+
+	return &Client{
+		addr:     addr,
+		job:      job,
+		grouping: o.grouping,
+		o:        o,
+		registry: registry,
+		recorder: recorder,
+		pusher:   pusher.Gatherer(registry).Client(recorder),
+		families: make(map[string]*clientFamily),
+	}, nil
+}
+
+// 用本轮的指标替换上一轮的值，一次请求推送到分组，失败时按选项重试。
+// 上一轮有而本轮没有的序列（例如已经消失的链）不会再被推送
+func (c *Client) Push(ctx context.Context, metrics []Metric) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.reset()
+	for _, m := range metrics {
+		if err := c.set(m); err != nil {
+			return err
+		}
+	}
+	if c.o.timestampMetric != "" {
+		err := c.set(Metric{Name: c.o.timestampMetric, Help: "Unix time of the last push of this group", Value: float64(time.Now().UnixNano()) / 1e9})
+		if err != nil {
+			return err
+		}
+	}
+
+	send := c.pusher.PushContext
+	if c.o.add {
+		send = c.pusher.AddContext
+	}
+	if err := c.o.retry(ctx, c.recorder, send); err != nil {
+		return c.o.redact(err)
+	}
+
+	trackGroup(c.addr, c.job, c.grouping)
+	return nil
+}
+
+// 清空上一轮设置的所有值，调用方需要持有 c.mu
+func (c *Client) reset() {
+	for _, f := range c.families {
+		switch {
+		case f.gauge != nil:
+			f.gauge.Reset()
+		case f.counter != nil:
+			f.counter.Reset()
+		case f.histogram != nil:
+			f.histogram.Reset()
+		}
+	}
+}
+
+// 设置一个指标的值，counter 的 Value 为累计值，调用方需要持有 c.mu
+func (c *Client) set(m Metric) error {
+	names, values := sortedLabels(m.Labels)
+	f, err := c.family(m, names)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case f.gauge != nil:
+		f.gauge.WithLabelValues(values...).Set(m.Value)
+	case f.counter != nil:
+		if m.Value < 0 {
+			return fmt.Errorf("counter %s 的值不能为负数: %v", m.Name, m.Value)
+		}
+		// counter 只能增加，先删除旧值再从 0 加到累计值
+		f.counter.DeleteLabelValues(values...)
+		f.counter.WithLabelValues(values...).Add(m.Value)
+	case f.histogram != nil:
+		f.histogram.DeleteLabelValues(values...)
+		observer := f.histogram.WithLabelValues(values...)
+		for _, v := range m.Observations {
+			observer.Observe(v)
+		}
+	}
+	return nil
+}
+
+// 返回指标名对应的 collector，第一次出现时创建并注册，调用方需要持有 c.mu
+func (c *Client) family(m Metric, labelNames []string) (*clientFamily, error) {
+	name, help := m.Name, m.Help
+	if f, ok := c.families[name]; ok {
+		if f.typ != m.Type {
+			return nil, fmt.Errorf("指标 %s 的类型与之前的不一致", name)
+		}
+		if strings.Join(f.labelNames, ",") != strings.Join(labelNames, ",") {
+			return nil, fmt.Errorf("指标 %s 的标签 %v 与之前的 %v 不一致", name, labelNames, f.labelNames)
+		}
+		return f, nil
+	}
+
+	for _, l := range labelNames {
+		if _, ok := c.o.constLabels[l]; ok {
+			return nil, fmt.Errorf("指标 %s: 标签 %s 与常量标签冲突", name, l)
+		}
+	}
+	if help == "" {
+		help = name
+	}
+
+	f := &clientFamily{typ: m.Type, labelNames: labelNames}
+	var collector prometheus.Collector
+	switch m.Type {
+	case Gauge:
+		f.gauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help, ConstLabels: c.o.constLabels}, labelNames)
+		collector = f.gauge
+	case Counter:
+		f.counter = prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help, ConstLabels: c.o.constLabels}, labelNames)
+		collector = f.counter
+	case Histogram:
+		f.histogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: help, Buckets: m.Buckets, ConstLabels: c.o.constLabels}, labelNames)
+		collector = f.histogram
+	default:
+		return nil, fmt.Errorf("指标 %s 的类型 %d 不支持", name, m.Type)
+	}
+	if err := c.registry.Register(collector); err != nil {
+		return nil, fmt.Errorf("注册指标 %s 失败: %v", name, err)
+	}
+	c.families[name] = f
+	return f, nil
+}
+
+// 按标签名排序返回标签名和对应的值
+func sortedLabels(labels map[string]string) ([]string, []string) {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	values := make([]string, len(names))
+	for i, name := range names {
+		values[i] = labels[name]
+	}
+	return names, values
+}
@@ -0,0 +1,371 @@
+package promth
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+// 丢弃请求体并返回 200 的 Pushgateway
+func newDiscardGateway(b *testing.B) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+	b.Cleanup(server.Close)
+	return server
+}
+
+// 一轮 100 个链、每个链 3 个指标
+func benchMetrics() []Metric {
+	var metrics []Metric
+	for i := 0; i < 100; i++ {
+		labels := map[string]string{"chain": fmt.Sprintf("chain%03d", i)}
+		metrics = append(metrics,
+			Metric{Name: "oula_shares_epoch_count", Help: "Share count of the latest epoch", Labels: labels, Value: float64(i)},
+			Metric{Name: "oula_shares_max_epoch", Help: "Latest epoch", Labels: labels, Value: float64(i * 10)},
+			Metric{Name: "oula_shares_pushes_total", Labels: labels, Value: float64(i), Type: Counter},
+		)
+	}
+	return metrics
+}
+
+// 复用同一个 Client，与 BenchmarkPushAll 比较每轮的分配
+func BenchmarkClientPush(b *testing.B) {
+	server := newDiscardGateway(b)
+	client, err := NewClient(server.URL, "oula-shares-push")
+	if err != nil {
+		b.Fatal(err)
+	}
+	metrics := benchMetrics()
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := client.Push(ctx, metrics); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// 每轮创建新的 Client、Pusher 和 registry
+func BenchmarkPushAll(b *testing.B) {
+	server := newDiscardGateway(b)
+	metrics := benchMetrics()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := PushAll(server.URL, "oula-shares-push", nil, metrics); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Pushgateway 收到的一个请求
+type gatewayRequest struct {
+	method      string
+	path        string
+	auth        string
+	contentType string
+	body        string
+}
+
+// 记录收到的请求，按 statuses 的顺序返回状态码，用完后返回 200
+type fakeGateway struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []gatewayRequest
+}
+
+func newFakeGateway(t *testing.T, statuses ...int) (*fakeGateway, *httptest.Server) {
+	gw := &fakeGateway{statuses: statuses}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gw.mu.Lock()
+		gw.requests = append(gw.requests, gatewayRequest{
+			method:      r.Method,
+			path:        r.URL.EscapedPath(),
+			auth:        r.Header.Get("Authorization"),
+			contentType: r.Header.Get("Content-Type"),
+			body:        string(body),
+		})
+		status := http.StatusOK
+		if len(gw.statuses) > 0 {
+			status, gw.statuses = gw.statuses[0], gw.statuses[1:]
+		}
+		gw.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return gw, server
+}
+
+func (gw *fakeGateway) received() []gatewayRequest {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	return append([]gatewayRequest(nil), gw.requests...)
+}
+
+func newTestClient(t *testing.T, addr string, opts ...Option) *Client {
+	t.Helper()
+	client, err := NewClient(addr, "oula-shares-push", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+var testMetrics = []Metric{{Name: "oula_shares_epoch_count", Labels: map[string]string{"chain": "aleo"}, Value: 3}}
+
+func TestClientGrouping(t *testing.T) {
+	gw, server := newFakeGateway(t)
+	client := newTestClient(t, server.URL, WithGrouping(map[string]string{"chain": "aleo", "pool": "a/b"}))
+	// 分组标签不能再出现在指标的标签中
+	if err := client.Push(context.Background(), testMetrics); err == nil {
+		t.Error("指标标签与分组标签重名时应当返回错误")
+	}
+	if err := client.Push(context.Background(), []Metric{{Name: "oula_shares_max_epoch", Value: 10}}); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := gw.received()
+	if len(reqs) != 1 {
+		t.Fatalf("收到 %d 个请求, 期望 1 个", len(reqs))
+	}
+	// 分组标签的顺序不固定，带 / 的标签值按 Pushgateway 的约定用 base64 编码
+	path := reqs[0].path
+	if !strings.HasPrefix(path, "/metrics/job/oula-shares-push/") || !strings.Contains(path, "/chain/aleo") || !strings.Contains(path, "/pool@base64/YS9i") {
+		t.Errorf("路径为 %s", path)
+	}
+
+	for _, grouping := range []map[string]string{
+		{"chain": ""},
+		{"bad-name": "aleo"},
+	} {
+		if _, err := NewClient(server.URL, "oula-shares-push", WithGrouping(grouping)); err == nil {
+			t.Errorf("分组 %v 应当返回错误", grouping)
+		}
+	}
+	if _, err := NewClient(server.URL, "oula-shares-push", WithGrouping(map[string]string{"chain": "aleo"}), WithConstLabels(map[string]string{"chain": "aleo"})); err == nil {
+		t.Error("常量标签与分组标签重名时应当返回错误")
+	}
+}
+
+func TestClientAdd(t *testing.T) {
+	for _, tt := range []struct {
+		opts   []Option
+		method string
+	}{
+		{nil, http.MethodPut},
+		{[]Option{WithAdd()}, http.MethodPost},
+	} {
+		gw, server := newFakeGateway(t)
+		client := newTestClient(t, server.URL, tt.opts...)
+		if err := client.Push(context.Background(), testMetrics); err != nil {
+			t.Fatal(err)
+		}
+		if reqs := gw.received(); len(reqs) != 1 || reqs[0].method != tt.method {
+			t.Errorf("请求 %+v, 期望一个 %s 请求", reqs, tt.method)
+		}
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		statuses []int
+		retries  int
+		requests int
+		wantErr  bool
+	}{
+		{"5xx 后成功", []int{503, 502}, 3, 3, false},
+		{"429 重试", []int{429}, 3, 2, false},
+		{"4xx 不重试", []int{400}, 3, 1, true},
+		{"重试用完", []int{503, 503, 503}, 2, 3, true},
+		{"不重试", []int{503}, 0, 1, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			gw, server := newFakeGateway(t, tt.statuses...)
+			client := newTestClient(t, server.URL, WithRetry(tt.retries, time.Millisecond))
+			err := client.Push(context.Background(), testMetrics)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("错误为 %v, 期望出错: %v", err, tt.wantErr)
+			}
+			if reqs := gw.received(); len(reqs) != tt.requests {
+				t.Errorf("收到 %d 个请求, 期望 %d 个", len(reqs), tt.requests)
+			}
+		})
+	}
+}
+
+// 不响应的 Pushgateway，请求取消或测试结束时返回
+func newHangingGateway(t *testing.T) *httptest.Server {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	t.Cleanup(func() {
+		close(done)
+		server.Close()
+	})
+	return server
+}
+
+func TestClientTimeout(t *testing.T) {
+	server := newHangingGateway(t)
+	client := newTestClient(t, server.URL, WithTimeout(50*time.Millisecond))
+
+	start := time.Now()
+	if err := client.Push(context.Background(), testMetrics); err == nil {
+		t.Fatal("请求超时应当返回错误")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("超时后 %v 才返回", elapsed)
+	}
+}
+
+func TestClientDeadline(t *testing.T) {
+	gw, server := newFakeGateway(t, 503, 503, 503, 503, 503, 503, 503, 503, 503, 503)
+	client := newTestClient(t, server.URL, WithRetry(100, 50*time.Millisecond), WithDeadline(200*time.Millisecond))
+
+	start := time.Now()
+	if err := client.Push(context.Background(), testMetrics); err == nil {
+		t.Fatal("一直失败的推送应当返回错误")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("截止时间过后 %v 才返回", elapsed)
+	}
+	if n := len(gw.received()); n < 2 || n > 10 {
+		t.Errorf("截止时间内收到 %d 个请求", n)
+	}
+
+	// 截止时间也限制单个请求
+	client = newTestClient(t, newHangingGateway(t).URL, WithDeadline(50*time.Millisecond))
+	start = time.Now()
+	if err := client.Push(context.Background(), testMetrics); err == nil {
+		t.Fatal("超过截止时间的请求应当返回错误")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("截止时间过后 %v 才返回", elapsed)
+	}
+}
+
+func TestClientBasicAuth(t *testing.T) {
+	gw, server := newFakeGateway(t, http.StatusUnauthorized)
+	client := newTestClient(t, server.URL, WithBasicAuth("oula", "s3cret"))
+	if err := client.Push(context.Background(), testMetrics); err == nil {
+		t.Fatal("401 应当返回错误")
+	}
+	if err := client.Push(context.Background(), testMetrics); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := gw.received()
+	if len(reqs) != 2 {
+		t.Fatalf("收到 %d 个请求, 期望 2 个", len(reqs))
+	}
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", reqs[1].auth)
+	if user, pass, ok := req.BasicAuth(); !ok || user != "oula" || pass != "s3cret" {
+		t.Errorf("Authorization 为 %q", reqs[1].auth)
+	}
+
+	// 地址中的密码不出现在错误信息中
+	_, server = newFakeGateway(t, http.StatusInternalServerError)
+	u := strings.Replace(server.URL, "http://", "http://oula:s3cret@", 1)
+	client = newTestClient(t, u, WithBasicAuth("oula", "s3cret"))
+	err := client.Push(context.Background(), testMetrics)
+	if err == nil {
+		t.Fatal("500 应当返回错误")
+	}
+	if strings.Contains(err.Error(), "s3cret") {
+		t.Errorf("错误信息包含密码: %v", err)
+	}
+}
+
+func TestClientCounter(t *testing.T) {
+	gw, server := newFakeGateway(t)
+	client := newTestClient(t, server.URL, WithFormat(expfmt.NewFormat(expfmt.TypeTextPlain)))
+	metrics := append([]Metric{{Name: "oula_shares_pushes_total", Labels: map[string]string{"chain": "aleo"}, Value: 7, Type: Counter}}, testMetrics...)
+	if err := client.Push(context.Background(), metrics); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := gw.received()
+	if len(reqs) != 1 {
+		t.Fatalf("收到 %d 个请求, 期望 1 个", len(reqs))
+	}
+	if !strings.HasPrefix(reqs[0].contentType, "text/plain") {
+		t.Errorf("Content-Type 为 %s", reqs[0].contentType)
+	}
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(reqs[0].body))
+	if err != nil {
+		t.Fatalf("解析请求体失败: %v\n%s", err, reqs[0].body)
+	}
+	counter := families["oula_shares_pushes_total"]
+	if counter == nil || counter.GetType().String() != "COUNTER" || counter.Metric[0].GetCounter().GetValue() != 7 {
+		t.Errorf("counter 为 %v", counter)
+	}
+	if gauge := families["oula_shares_epoch_count"]; gauge == nil || gauge.GetType().String() != "GAUGE" {
+		t.Errorf("gauge 为 %v", gauge)
+	}
+
+	// counter 不能为负数，同名指标的类型不能改变
+	for _, m := range []Metric{
+		{Name: "oula_shares_pushes_total", Labels: map[string]string{"chain": "aleo"}, Value: -1, Type: Counter},
+		{Name: "oula_shares_pushes_total", Labels: map[string]string{"chain": "aleo"}, Value: 1},
+	} {
+		if err := client.Push(context.Background(), []Metric{m}); err == nil {
+			t.Errorf("%+v 应当返回错误", m)
+		}
+	}
+}
+
+// 记录经过的请求的 HTTPDoer
+type countingDoer struct {
+	mu    sync.Mutex
+	count int
+}
+
+func (d *countingDoer) Do(req *http.Request) (*http.Response, error) {
+	d.mu.Lock()
+	d.count++
+	d.mu.Unlock()
+	return http.DefaultClient.Do(req)
+}
+
+func TestClientHTTPClient(t *testing.T) {
+	_, server := newFakeGateway(t)
+	doer := &countingDoer{}
+	client := newTestClient(t, server.URL, WithHTTPClient(doer))
+	if err := client.Push(context.Background(), testMetrics); err != nil {
+		t.Fatal(err)
+	}
+	if doer.count != 1 {
+		t.Errorf("自定义客户端发出 %d 个请求, 期望 1 个", doer.count)
+	}
+
+	// TLS 的 Pushgateway 需要信任其证书的客户端
+	tlsServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tlsServer.Config.ErrorLog = log.New(io.Discard, "", 0)
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+	if err := newTestClient(t, tlsServer.URL).Push(context.Background(), testMetrics); err == nil {
+		t.Error("不信任证书时应当返回错误")
+	}
+	if err := newTestClient(t, tlsServer.URL, WithHTTPClient(tlsServer.Client())).Push(context.Background(), testMetrics); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,54 @@
+package promth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// 把同一轮的指标同时推送到多个 Pushgateway，每个 Client 使用自己的超时和重试，互不累加
+type FanOut struct {
+	clients    []*Client
+	requireAll bool
+	observe    func(addr string, err error)
+}
+
+var _ MetricPusher = (*FanOut)(nil)
+
+// requireAll 为 false 时至少一个地址推送成功即视为成功；
+// observe 不为 nil 时在每个地址推送完成后调用，用于按地址统计失败次数
+func NewFanOut(clients []*Client, requireAll bool, observe func(addr string, err error)) *FanOut {
+	return &FanOut{clients: clients, requireAll: requireAll, observe: observe}
+}
+
+func (f *FanOut) Push(ctx context.Context, metrics []Metric) error {
+	errs := make([]error, len(f.clients))
+	var wg sync.WaitGroup
+	for i, client := range f.clients {
+		i, client := i, client
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := client.Push(ctx, metrics)
+			if f.observe != nil {
+				f.observe(client.addr, err)
+			}
+			if err != nil {
+				errs[i] = fmt.Errorf("推送到 %s 失败: %w", redactAddr(client.addr), err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) == 0 || (!f.requireAll && len(failed) < len(f.clients)) {
+		return nil
+	}
+	return errors.Join(failed...)
+}
@@ -0,0 +1,86 @@
+package promth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// 进程生命周期内成功推送过的一个分组
+type group struct {
+	addr     string
+	job      string
+	grouping map[string]string
+}
+
+// 进程内推送过的所有分组，key 为地址、job 和分组标签拼接成的字符串
+var (
+	pushedGroups   = make(map[string]group)
+	pushedGroupsMu sync.Mutex
+)
+
+// 记录一个推送成功的分组
+func trackGroup(addr, job string, grouping map[string]string) {
+	names := make([]string, 0, len(grouping))
+	for name := range grouping {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	key := []string{addr, job}
+	copied := make(map[string]string, len(grouping))
+	for _, name := range names {
+		key = append(key, name+"="+grouping[name])
+		copied[name] = grouping[name]
+	}
+
+	pushedGroupsMu.Lock()
+	defer pushedGroupsMu.Unlock()
+	pushedGroups[strings.Join(key, "\x00")] = group{addr: addr, job: job, grouping: copied}
+}
+
+// 删除本进程推送过的所有分组，整个过程不超过 timeout，返回删除失败的错误
+func DeletePushed(timeout time.Duration, opts ...Option) []error {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	pushedGroupsMu.Lock()
+	groups := make([]group, 0, len(pushedGroups))
+	for _, g := range pushedGroups {
+		groups = append(groups, g)
+	}
+	pushedGroupsMu.Unlock()
+
+	var errs []error
+	for _, g := range groups {
+		pusher, err := newPusher(g.addr, g.job, g.grouping, o)
+		if err == nil {
+			err = pusher.Client(contextDoer{doer: o.httpClient(), ctx: ctx}).Delete()
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("删除分组 job=%s %v 失败: %v", g.job, g.grouping, o.redact(err)))
+		}
+	}
+	return errs
+}
+
+// 给每个请求带上 context，使没有 context 参数的 Pusher.Delete 也能按截止时间取消
+type contextDoer struct {
+	doer push.HTTPDoer
+	ctx  context.Context
+}
+
+func (d contextDoer) Do(req *http.Request) (*http.Response, error) {
+	return d.doer.Do(req.WithContext(d.ctx))
+}
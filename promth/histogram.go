@@ -0,0 +1,23 @@
+package promth
+
+import "context"
+
+// 推送一个以 chain 为标签的 histogram，observations 的 key 为链名，value 为该链的所有观测值。
+// 总是使用 POST（Pusher.Add），不会覆盖同一分组中 PushAll 推送的其他指标
+func PushHistogram(addr, job, name string, buckets []float64, observations map[string][]float64, opts ...Option) error {
+	client, err := NewClient(addr, job, append(opts, WithAdd())...)
+	if err != nil {
+		return err
+	}
+	metrics := make([]Metric, 0, len(observations))
+	for chain, values := range observations {
+		metrics = append(metrics, Metric{
+			Name:         name,
+			Labels:       map[string]string{"chain": chain},
+			Type:         Histogram,
+			Buckets:      buckets,
+			Observations: values,
+		})
+	}
+	return client.Push(context.Background(), metrics)
+}
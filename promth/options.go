@@ -0,0 +1,143 @@
+package promth
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/prometheus/common/expfmt"
+)
+
+// 推送选项
+type Option func(*options)
+
+type options struct {
+	username string
+	password string
+	client   push.HTTPDoer
+	timeout  time.Duration
+	format   expfmt.Format
+
+	add         bool
+	grouping    map[string]string
+	constLabels map[string]string
+
+	timestampMetric string
+
+	maxRPS  float64
+	limiter *limiter
+
+	retries      int
+	retryBackoff time.Duration
+	deadline     time.Duration
+}
+
+// 每次推送请求的超时时间，超时后立即返回错误
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// 使用自定义的 HTTP 客户端推送，例如配置了 TLS 的客户端
+func WithHTTPClient(client push.HTTPDoer) Option {
+	return func(o *options) {
+		o.client = client
+	}
+}
+
+// 推送使用的编码格式，默认为 protobuf delimited，只接受文本格式的代理可以使用 expfmt.NewFormat(expfmt.TypeTextPlain)
+func WithFormat(format expfmt.Format) Option {
+	return func(o *options) {
+		o.format = format
+	}
+}
+
+// 使用 HTTP basic auth 推送
+func WithBasicAuth(username, password string) Option {
+	return func(o *options) {
+		o.username = username
+		o.password = password
+	}
+}
+
+// 使用 Pusher.Add（POST）代替 Pusher.Push（PUT）：只更新本次推送的指标，
+// 分组中其他来源推送的指标保持不变
+func WithAdd() Option {
+	return func(o *options) {
+		o.add = true
+	}
+}
+
+// Client 推送的分组标签，不同分组（例如不同链）的推送互不覆盖
+func WithGrouping(grouping map[string]string) Option {
+	return func(o *options) {
+		o.grouping = grouping
+	}
+}
+
+// 作为 ConstLabels 加到每个注册的指标上，例如 instance 和 cluster，
+// 与分组标签不同，这些标签不影响 Pushgateway 中的分组
+func WithConstLabels(labels map[string]string) Option {
+	return func(o *options) {
+		o.constLabels = labels
+	}
+}
+
+// 每次推送时把名为 name 的 gauge 设为当前的 Unix 时间，随其他指标在同一个请求中推送，
+// 不依赖 Pushgateway 的 push_time_seconds 也能判断分组是否过期
+func WithTimestampMetric(name string) Option {
+	return func(o *options) {
+		o.timestampMetric = name
+	}
+}
+
+// 每个 Client 每秒最多发出 rps 个请求（包括重试），等待会超过截止时间时放弃推送并返回 ErrRateLimited
+func WithRateLimit(rps float64) Option {
+	return func(o *options) {
+		o.maxRPS = rps
+	}
+}
+
+// 推送失败后的重试次数和首次重试前的等待时间，之后每次等待时间翻倍并加入随机抖动
+func WithRetry(retries int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.retries = retries
+		o.retryBackoff = backoff
+	}
+}
+
+// 包括重试在内整个推送过程的最长时间，避免重试拖到下一轮
+func WithDeadline(deadline time.Duration) Option {
+	return func(o *options) {
+		o.deadline = deadline
+	}
+}
+
+// 没有指定 HTTP 客户端时使用 http.DefaultClient
+func (o options) httpClient() push.HTTPDoer {
+	if o.client == nil {
+		return http.DefaultClient
+	}
+	return o.client
+}
+
+// 去掉地址中可能带有的密码
+func redactAddr(addr string) string {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return addr
+	}
+	return u.Redacted()
+}
+
+// 去掉错误信息中可能出现的密码，例如地址中带有凭据时
+func (o options) redact(err error) error {
+	if err == nil || o.password == "" || !strings.Contains(err.Error(), o.password) {
+		return err
+	}
+	return errors.New(strings.ReplaceAll(err.Error(), o.password, "<redacted>"))
+}
@@ -0,0 +1,105 @@
+package promth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/prometheus/common/model"
+)
+
+// 指标类型
+type MetricType int
+
+const (
+	Gauge MetricType = iota
+	Counter
+	Histogram
+)
+
+// 一个要推送的指标，默认为 gauge
+type Metric struct {
+	Name   string
+	Help   string
+	Labels map[string]string
+	Value  float64
+	Type   MetricType
+
+	// 只用于 Histogram，同名 histogram 使用第一次出现时的 Buckets
+	Buckets      []float64
+	Observations []float64
+}
+
+// 把一轮的指标推送到某个目标，主循环只依赖这个接口，测试时可以替换为记录推送内容的实现
+type MetricPusher interface {
+	Push(ctx context.Context, metrics []Metric) error
+}
+
+var _ MetricPusher = (*Client)(nil)
+
+// 推送一个带标签的 gauge 到 Pushgateway
+func Push(addr, name, job string, labels map[string]string, value float64) error {
+	return PushWithGrouping(addr, name, job, nil, labels, value)
+}
+
+// 推送一个带标签的 counter，value 为累计值
+func PushCounter(addr, name, job string, labels map[string]string, value float64) error {
+	return PushAll(addr, job, nil, []Metric{{Name: name, Labels: labels, Value: value, Type: Counter}})
+}
+
+// 与 Push 相同，另外按 grouping 中的标签区分 Pushgateway 中的分组，
+// 不同分组（例如不同链）的推送互不覆盖
+func PushWithGrouping(addr, name, job string, grouping, labels map[string]string, value float64) error {
+	return PushAll(addr, job, grouping, []Metric{{Name: name, Labels: labels, Value: value}})
+}
+
+// 把所有指标用一次请求推送到同一个分组，Pushgateway 上的推送是原子的，失败时整体重试。
+// 每次调用都会创建新的 Client，需要周期性推送时直接复用 Client
+func PushAll(addr, job string, grouping map[string]string, metrics []Metric, opts ...Option) error {
+	client, err := NewClient(addr, job, append(opts, WithGrouping(grouping))...)
+	if err != nil {
+		return err
+	}
+	return client.Push(context.Background(), metrics)
+}
+
+// 检查常量标签：job 由 Pushgateway 设置，分组标签会覆盖同名的常量标签，
+// 两种冲突都会让 Pushgateway 返回 400，所以提前报错
+func CheckConstLabels(constLabels, grouping map[string]string) error {
+	for name := range constLabels {
+		if !model.LabelName(name).IsValid() {
+			return fmt.Errorf("常量标签名 %q 不合法", name)
+		}
+		if name == "job" {
+			return fmt.Errorf("常量标签不能使用 job，job 由推送时的 job 名决定")
+		}
+		if _, ok := grouping[name]; ok {
+			return fmt.Errorf("常量标签 %s 同时被用作分组标签", name)
+		}
+	}
+	return nil
+}
+
+// 创建 Pusher，在发出 HTTP 请求前校验分组标签
+func newPusher(addr, job string, grouping map[string]string, o options) (*push.Pusher, error) {
+	if err := CheckConstLabels(o.constLabels, grouping); err != nil {
+		return nil, err
+	}
+	pusher := push.New(addr, job).Client(o.httpClient())
+	if o.username != "" || o.password != "" {
+		pusher = pusher.BasicAuth(o.username, o.password)
+	}
+	if o.format != "" {
+		pusher = pusher.Format(o.format)
+	}
+	for name, value := range grouping {
+		if !model.LabelName(name).IsValid() {
+			return nil, fmt.Errorf("分组标签名 %q 不合法", name)
+		}
+		if value == "" {
+			return nil, fmt.Errorf("分组标签 %s 的值不能为空", name)
+		}
+		pusher = pusher.Grouping(name, value)
+	}
+	return pusher, nil
+}
@@ -0,0 +1,71 @@
+package promth
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// 等待令牌会超过本次推送的截止时间（下一轮开始）时返回的错误，推送被放弃而不是延后
+var ErrRateLimited = errors.New("推送速率超过限制，等待会超过本轮截止时间，放弃本次推送")
+
+// 令牌桶限速器，每秒补充 rate 个令牌，最多积攒 burst 个
+type limiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(rate float64) *limiter {
+	burst := math.Max(1, rate)
+	return &limiter{rate: rate, burst: burst, tokens: burst, now: time.Now}
+}
+
+// 取出一个令牌，返回需要等待的时间；等待时间会超过 deadline 时不取令牌并返回 false
+func (l *limiter) reserve(deadline time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if !l.last.IsZero() {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+
+	var wait time.Duration
+	if l.tokens < 1 {
+		wait = time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+	}
+	if !deadline.IsZero() && now.Add(wait).After(deadline) {
+		return 0, false
+	}
+	l.tokens--
+	return wait, true
+}
+
+// 等待直到可以发出下一个请求
+func (l *limiter) wait(ctx context.Context) error {
+	deadline, _ := ctx.Deadline()
+	wait, ok := l.reserve(deadline)
+	if !ok {
+		return ErrRateLimited
+	}
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,82 @@
+package promth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// 使用可以手动推进的时钟的限速器
+func newTestLimiter(rate float64) (*limiter, func(time.Duration)) {
+	now := time.Now()
+	l := newLimiter(rate)
+	l.now = func() time.Time { return now }
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestLimiterReserve(t *testing.T) {
+	l, advance := newTestLimiter(2)
+	expect := func(deadline time.Time, wantWait time.Duration, wantOK bool) {
+		t.Helper()
+		wait, ok := l.reserve(deadline)
+		if wait != wantWait || ok != wantOK {
+			t.Errorf("reserve 返回 %v, %v，应为 %v, %v", wait, ok, wantWait, wantOK)
+		}
+	}
+
+	// 开始时有 burst 个令牌
+	expect(time.Time{}, 0, true)
+	expect(time.Time{}, 0, true)
+	// 令牌用完后每个请求等待 1/rate
+	expect(time.Time{}, 500*time.Millisecond, true)
+	expect(time.Time{}, time.Second, true)
+
+	// 之前的请求预支了 2 个令牌，2 秒补充 4 个后剩下 2 个
+	advance(2 * time.Second)
+	expect(time.Time{}, 0, true)
+	expect(time.Time{}, 0, true)
+
+	// 等待会超过截止时间时放弃，不消耗令牌
+	deadline := l.now().Add(100 * time.Millisecond)
+	expect(deadline, 0, false)
+	expect(deadline, 0, false)
+	advance(250 * time.Millisecond)
+	expect(l.now().Add(250*time.Millisecond), 250*time.Millisecond, true)
+
+	// 长时间空闲后同样最多只有 burst 个令牌
+	advance(time.Hour)
+	expect(time.Time{}, 0, true)
+	expect(time.Time{}, 0, true)
+	expect(time.Time{}, 500*time.Millisecond, true)
+}
+
+// 速率小于 1 时 burst 为 1
+func TestLimiterSlowRate(t *testing.T) {
+	l, advance := newTestLimiter(0.5)
+	if wait, ok := l.reserve(time.Time{}); wait != 0 || !ok {
+		t.Fatalf("第一个请求等待 %v, %v", wait, ok)
+	}
+	if wait, _ := l.reserve(time.Time{}); wait != 2*time.Second {
+		t.Errorf("第二个请求等待 %v，应为 2s", wait)
+	}
+	advance(4 * time.Second)
+	if wait, _ := l.reserve(time.Time{}); wait != 0 {
+		t.Errorf("补充令牌后等待 %v", wait)
+	}
+}
+
+// ctx 的截止时间之前等不到令牌时返回 ErrRateLimited
+func TestLimiterWaitDeadline(t *testing.T) {
+	l, _ := newTestLimiter(1)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	if err := l.wait(ctx); err != nil {
+		t.Fatalf("第一个请求返回 %v", err)
+	}
+	short, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := l.wait(short); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("等待会超过截止时间时返回 %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package promth
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// 记录最近一次响应的状态码，用于判断推送失败是否值得重试
+type statusRecorder struct {
+	doer push.HTTPDoer
+
+	mu     sync.Mutex
+	status int
+}
+
+func (r *statusRecorder) Do(req *http.Request) (*http.Response, error) {
+	resp, err := r.doer.Do(req)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = 0
+	if resp != nil {
+		r.status = resp.StatusCode
+	}
+	return resp, err
+}
+
+func (r *statusRecorder) lastStatus() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+// 按指数退避重试推送，429 以外的 4xx 说明请求本身有问题，不再重试；
+// 全部重试都失败时返回最后一次的错误
+func (o options) retry(ctx context.Context, recorder *statusRecorder, push func(context.Context) error) error {
+	if o.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.deadline)
+		defer cancel()
+	}
+
+	b := backoff.NewExponentialBackOff()
+	if o.retryBackoff > 0 {
+		b.InitialInterval = o.retryBackoff
+	}
+	b.Multiplier = 2
+	b.MaxElapsedTime = o.deadline
+
+	attempt := func() error {
+		if o.limiter != nil {
+			if err := o.limiter.wait(ctx); err != nil {
+				return backoff.Permanent(err)
+			}
+		}
+
+		attemptCtx := ctx
+		if o.timeout > 0 {
+			var cancel context.CancelFunc
+			attemptCtx, cancel = context.WithTimeout(ctx, o.timeout)
+			defer cancel()
+		}
+
+		err := push(attemptCtx)
+		if err == nil {
+			return nil
+		}
+		if status := recorder.lastStatus(); status >= 400 && status < 500 && status != http.StatusTooManyRequests {
+			return backoff.Permanent(err)
+		}
+		return err
+	}
+
+	return backoff.Retry(attempt, backoff.WithContext(backoff.WithMaxRetries(b, uint64(max(o.retries, 0))), ctx))
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+
+	"oula-shares-push/promth"
+)
+
+var (
+	// 指定多个地址时同时推送到每个 Pushgateway
+	pushRequireAll = flag.Bool("push-require-all", false, "With several -push-addr, fail the cycle unless every Pushgateway push succeeds instead of at least one")
+	pushJob        = flag.String("push-job", "oula-shares-push", "Job name used for Pushgateway pushes")
+
+	pushUsername     = flag.String("push-username", "", "Username for Pushgateway basic auth")
+	pushPasswordFile = flag.String("push-password-file", "", "File containing the Pushgateway basic auth password, the "+pushPasswordEnv+" environment variable is used when empty")
+	// 文件变化后自动重新读取，轮换 token 不需要重启
+	pushBearerTokenFile = flag.String("push-bearer-token-file", "", "File containing a token sent as 'Authorization: Bearer <token>' with every push, re-read when it changes")
+
+	// 默认使用 replace，每轮推送替换分组中的全部指标
+	pushMode             = flag.String("push-mode", pushModeReplace, "Pushgateway push mode: replace (PUT, replaces the whole group) or add (POST, only updates the pushed metrics)")
+	pushDeleteOnShutdown = flag.Bool("push-delete-on-shutdown", false, "Delete every Pushgateway group pushed by this process on SIGTERM/SIGINT")
+	pushTimeout          = flag.Duration("push-timeout", 10*time.Second, "Timeout of each Pushgateway request")
+	pushRetries          = flag.Int("push-retries", 3, "Number of retries after a failed Pushgateway push")
+	pushRetryBackoff     = flag.Duration("push-retry-backoff", 2*time.Second, "Wait before the first push retry, doubled with jitter for each further retry")
+
+	pushCAFile             = flag.String("push-ca-file", "", "CA bundle used to verify the Pushgateway certificate")
+	pushCertFile           = flag.String("push-cert-file", "", "Client certificate for Pushgateway TLS")
+	pushKeyFile            = flag.String("push-key-file", "", "Client key for Pushgateway TLS")
+	pushInsecureSkipVerify = flag.Bool("push-insecure-skip-verify", false, "Skip Pushgateway certificate verification, last resort only")
+
+	pushStartupProbe     = flag.Bool("push-startup-probe", false, "Check at startup that -push-addr resolves and GET /-/ready succeeds before entering the loop")
+	pushMaxRPS           = flag.Float64("push-max-rps", 0, "Maximum Pushgateway requests per second for each -push-addr including retries, 0 disables; pushes that would wait past the next cycle are dropped")
+	pushMaxIdleConns     = flag.Int("push-max-idle-conns", 2, "Maximum number of idle keep-alive connections kept to the Pushgateway")
+	pushDisableKeepalive = flag.Bool("push-disable-keepalive", false, "Open a new connection for every Pushgateway request, e.g. for proxies that mishandle keep-alive")
+
+	// 需要额外查询每个 epoch 的 share count，默认关闭
+	pushTimestampMetric  = flag.Bool("push-timestamp-metric", false, "Add "+pushedAtMetricName+", the Unix time of the push, to every Pushgateway push")
+	pushInstanceHostname = flag.Bool("push-instance-hostname", false, "Add instance=<hostname> to every pushed series")
+
+	pushHistogram    = flag.Bool("push-histogram", false, "Also push "+histogramMetricName+", a histogram of the share counts of the last -max-epochs epochs per chain")
+	histogramBuckets = flag.String("histogram-buckets", "100,1000,10000,100000,1000000,10000000", "Comma separated upper bounds of the -push-histogram buckets")
+)
+
+const (
+	// 各链最近 epoch 的 share count 分布
+	histogramMetricName = "oula_shares_epoch_shares"
+	// 每次推送的时间，用于对分组的新鲜度报警
+	pushedAtMetricName = "oula_shares_pushed_at_seconds"
+)
+
+// 解析后的 -histogram-buckets
+var parsedHistogramBuckets []float64
+
+// -push-label 指定的常量标签
+var pushConstLabels = make(labelsFlag)
+
+func init() {
+	flag.Var(pushConstLabels, "push-label", "Constant key=value label added to every pushed series but not to files, repeatable or comma separated (e.g. -push-label cluster=eu1)")
+}
+
+// 可以重复指定或用逗号分隔的多个值
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// -push-addr 指定的所有 Pushgateway 地址
+var pushAddrs listFlag
+
+func init() {
+	flag.Var(&pushAddrs, "push-addr", "Pushgateway address, required by -mode=pushgateway|both; repeatable or comma separated to push to several gateways")
+}
+
+// -push-format 的取值，解析参数时即校验
+type pushFormatFlag string
+
+const (
+	pushFormatText  = "text"
+	pushFormatProto = "proto"
+)
+
+func (f *pushFormatFlag) String() string {
+	return string(*f)
+}
+
+func (f *pushFormatFlag) Set(value string) error {
+	if value != pushFormatText && value != pushFormatProto {
+		return fmt.Errorf("可选值为 %s 或 %s", pushFormatText, pushFormatProto)
+	}
+	*f = pushFormatFlag(value)
+	return nil
+}
+
+// 对应的 expfmt 编码格式
+func (f *pushFormatFlag) format() expfmt.Format {
+	if *f == pushFormatText {
+		return expfmt.NewFormat(expfmt.TypeTextPlain)
+	}
+	return expfmt.NewFormat(expfmt.TypeProtoDelim)
+}
+
+var pushFormat = pushFormatFlag(pushFormatProto)
+
+func init() {
+	flag.Var(&pushFormat, "push-format", "Encoding of Pushgateway pushes: proto (delimited protobuf) or text, for ingestion proxies that only accept the text format")
+}
+
+// -push-mode 支持的推送方式
+const (
+	pushModeReplace = "replace"
+	pushModeAdd     = "add"
+)
+
+// 不通过命令行传递密码，避免出现在 ps 输出中
+const pushPasswordEnv = "PUSH_PASSWORD"
+
+// 退出前删除分组的最长等待时间
+const pushDeleteTimeout = 5 * time.Second
+
+// 收到 SIGTERM/SIGINT 退出前删除推送过的分组，删除失败只记录日志
+func deletePushedOnShutdown(opts []promth.Option) {
+	if !pushModeEnabled() || !*pushDeleteOnShutdown {
+		return
+	}
+
+	slog.Info("正在删除已推送的 Pushgateway 分组")
+	for _, err := range promth.DeletePushed(pushDeleteTimeout, opts...) {
+		slog.Error("删除 Pushgateway 分组时出错", "err", err)
+	}
+}
+
+// 把本轮所有链的指标用一次请求推送到每个 Pushgateway，链名作为 chain 标签
+func pushMetrics(ctx context.Context, data *cycleData, pusher promth.MetricPusher) error {
+	metrics := cycleMetrics(data)
+	slog.Info("正在推送指标到 Pushgateway", "chains", len(data.chains), "metrics", len(metrics))
+	return pusher.Push(ctx, metrics)
+}
+
+// 为每个 -push-addr 创建 Client，同时推送到所有地址，按地址统计推送结果
+func newPusher(opts []promth.Option) (promth.MetricPusher, error) {
+	clients := make([]*promth.Client, 0, len(pushAddrs))
+	for _, addr := range pushAddrs {
+		client, err := promth.NewClient(addr, *pushJob, opts...)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return promth.NewFanOut(clients, *pushRequireAll, func(addr string, err error) {
+		recordPush(addr, err)
+		if err != nil && len(clients) > 1 {
+			slog.Error("推送到 Pushgateway 时出错", "target", pushTarget(addr), "err", err)
+		}
+	}), nil
+}
+
+// 本轮要推送的所有指标，只依赖查询结果，不访问网络
+func cycleMetrics(data *cycleData) []promth.Metric {
+	// 查询耗时等自身指标同样推送，只使用 pushgateway 模式时也能看到
+	families := outputFamilies(data)
+
+	var metrics []promth.Metric
+	for _, family := range families {
+		for _, s := range family.samples {
+			labels := make(map[string]string, len(s.labels))
+			for _, l := range s.labels {
+				// 旧版指标自带的 job 会被 client_golang 拒绝，instance 与 -push-instance-hostname 冲突，
+				// 推送时两者都由分组决定，指标名中已经包含链名
+				if *legacyMetricNames && (l.name == "job" || l.name == "instance") {
+					continue
+				}
+				labels[l.name] = l.value
+			}
+			metric := promth.Metric{Name: family.name, Help: family.help, Labels: labels, Value: s.value}
+			if family.counter {
+				metric.Type = promth.Counter
+			}
+			metrics = append(metrics, metric)
+		}
+	}
+
+	// histogram 与其他指标在同一个请求中推送，同样带上 -label 指定的标签
+	if *pushHistogram {
+		for _, chain := range data.chains {
+			labels := make(map[string]string)
+			for _, p := range chainLabels(chain, data.stats[chain]) {
+				labels[p.name] = p.value
+			}
+			for _, p := range extraLabels.pairs() {
+				labels[p.name] = p.value
+			}
+			metric := promth.Metric{
+				Name:    *metricPrefix + histogramMetricName,
+				Help:    "Share counts of the most recent epochs of the chain",
+				Labels:  labels,
+				Type:    promth.Histogram,
+				Buckets: parsedHistogramBuckets,
+			}
+			epochShares := latestEpochs(data.epochShares[chain], *maxEpochs)
+			for _, epoch := range sortedEpochs(epochShares) {
+				metric.Observations = append(metric.Observations, float64(epochShares[epoch]))
+			}
+			metrics = append(metrics, metric)
+		}
+	}
+
+	return metrics
+}
+
+// dry-run 模式下代替 Pushgateway，只记录将要推送的内容
+type dryRunPusher struct{}
+
+func (dryRunPusher) Push(_ context.Context, metrics []promth.Metric) error {
+	slog.Info("dry-run：跳过推送指标到 Pushgateway", "metrics", len(metrics))
+	return nil
+}
+
+// 解析 -histogram-buckets，上界必须严格递增
+func parseHistogramBuckets(value string) ([]float64, error) {
+	var buckets []float64
+	for _, field := range strings.Split(value, ",") {
+		bound, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, fmt.Errorf("-histogram-buckets 中的 %q 不是数字", field)
+		}
+		if len(buckets) > 0 && bound <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("-histogram-buckets 的上界必须严格递增: %s", value)
+		}
+		buckets = append(buckets, bound)
+	}
+	return buckets, nil
+}
+
+// 根据命令行参数构建推送选项
+func pushOptions() ([]promth.Option, error) {
+	// 重试不能拖到下一轮轮询
+	opts := []promth.Option{
+		promth.WithTimeout(*pushTimeout),
+		promth.WithRetry(*pushRetries, *pushRetryBackoff),
+		promth.WithDeadline(cycleInterval),
+		promth.WithFormat(pushFormat.format()),
+	}
+	if *pushMaxRPS > 0 {
+		opts = append(opts, promth.WithRateLimit(*pushMaxRPS))
+	}
+	if *pushTimestampMetric {
+		opts = append(opts, promth.WithTimestampMetric(*metricPrefix+pushedAtMetricName))
+	}
+
+	switch *pushMode {
+	case pushModeReplace:
+	case pushModeAdd:
+		opts = append(opts, promth.WithAdd())
+	default:
+		return nil, fmt.Errorf("不支持的 -push-mode %q，可选值为 %s 或 %s", *pushMode, pushModeReplace, pushModeAdd)
+	}
+
+	if *pushHistogram {
+		buckets, err := parseHistogramBuckets(*histogramBuckets)
+		if err != nil {
+			return nil, err
+		}
+		parsedHistogramBuckets = buckets
+	}
+
+	constLabels, err := pushLabels()
+	if err != nil {
+		return nil, err
+	}
+	if len(constLabels) > 0 {
+		opts = append(opts, promth.WithConstLabels(constLabels))
+	}
+
+	password := os.Getenv(pushPasswordEnv)
+	if *pushPasswordFile != "" {
+		content, err := os.ReadFile(*pushPasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取 -push-password-file 失败: %v", err)
+		}
+		password = strings.TrimRight(string(content), "\r\n")
+	}
+	if *pushUsername != "" || password != "" {
+		if *pushBearerTokenFile != "" {
+			return nil, fmt.Errorf("basic auth 和 -push-bearer-token-file 不能同时使用")
+		}
+		opts = append(opts, promth.WithBasicAuth(*pushUsername, password))
+	}
+
+	client, err := pushHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, promth.WithHTTPClient(client))
+
+	if *pushStartupProbe {
+		for _, addr := range pushAddrs {
+			if err := probePushgateway(client, addr, *pushUsername, password); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return opts, nil
+}
+
+// 校验 -push-addr，避免地址写错时要等到第一次推送才看到难以理解的错误
+func validatePushAddr(addr string) error {
+	if strings.TrimSpace(addr) != addr {
+		return fmt.Errorf("-push-addr %q 前后包含空白字符", addr)
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return fmt.Errorf("-push-addr %q 不是合法的 URL: %v", addr, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("-push-addr %q 需要以 http:// 或 https:// 开头，例如 http://pushgateway:9091", addr)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("-push-addr %q 中缺少主机名", addr)
+	}
+	return nil
+}
+
+// 启动时确认 Pushgateway 可以访问：解析域名并请求 /-/ready
+func probePushgateway(client *http.Client, addr, username, password string) error {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return err
+	}
+	if _, err := net.LookupHost(u.Hostname()); err != nil {
+		return fmt.Errorf("无法解析 -push-addr 的主机名 %s: %v", u.Hostname(), err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *pushTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(addr, "/")+"/-/ready", nil)
+	if err != nil {
+		return err
+	}
+	if username != "" || password != "" {
+		req.SetBasicAuth(username, password)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("无法访问 Pushgateway %s: %v", pushTarget(addr), err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Pushgateway %s 未就绪: %s", pushTarget(addr), resp.Status)
+	}
+	return nil
+}
+
+// 启动时创建一次推送用的 HTTP 客户端，之后每次推送复用同一个 Transport 和其中的空闲连接。
+// 代理按 HTTP_PROXY、HTTPS_PROXY 和 NO_PROXY 环境变量选择
+func pushHTTPClient() (*http.Client, error) {
+	tlsConfig, err := pushTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	transport.TLSClientConfig = tlsConfig
+	transport.MaxIdleConns = *pushMaxIdleConns
+	transport.MaxIdleConnsPerHost = *pushMaxIdleConns
+	transport.DisableKeepAlives = *pushDisableKeepalive
+
+	if *pushBearerTokenFile == "" {
+		return &http.Client{Transport: transport}, nil
+	}
+	withToken, err := promth.BearerTokenFileTransport(transport, *pushBearerTokenFile)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: withToken}, nil
+}
+
+// 汇总 -push-label 和 -push-instance-hostname 指定的常量标签，冲突时返回错误，
+// 避免启动后每次推送都被 Pushgateway 拒绝
+func pushLabels() (map[string]string, error) {
+	// 与 -label 相同的保留标签，推送的指标中没有 instance，可以作为常量标签
+	reserved := make(map[string]bool, len(reservedLabels))
+	for name := range reservedLabels {
+		reserved[name] = name != "instance"
+	}
+	if err := checkReservedLabels("-push-label", pushConstLabels, reserved); err != nil {
+		return nil, err
+	}
+
+	labels := make(map[string]string, len(pushConstLabels)+1)
+	for _, p := range pushConstLabels.pairs() {
+		if _, ok := extraLabels[p.name]; ok {
+			return nil, fmt.Errorf("标签 %s 同时由 -label 和 -push-label 指定", p.name)
+		}
+		labels[p.name] = p.value
+	}
+
+	if *pushInstanceHostname {
+		if _, ok := labels["instance"]; ok {
+			return nil, fmt.Errorf("-push-instance-hostname 与 -push-label instance=... 不能同时指定")
+		}
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("获取主机名失败: %v", err)
+		}
+		labels["instance"] = hostname
+	}
+
+	if err := promth.CheckConstLabels(labels, nil); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+// 根据 -push-ca-file 等参数构建 TLS 配置，没有任何 TLS 参数时返回 nil
+func pushTLSConfig() (*tls.Config, error) {
+	if *pushCAFile == "" && *pushCertFile == "" && *pushKeyFile == "" && !*pushInsecureSkipVerify {
+		return nil, nil
+	}
+
+	config := &tls.Config{InsecureSkipVerify: *pushInsecureSkipVerify}
+
+	if *pushCAFile != "" {
+		ca, err := os.ReadFile(*pushCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取 -push-ca-file %s 失败: %v", *pushCAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("-push-ca-file %s 中没有有效的 PEM 证书", *pushCAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if (*pushCertFile == "") != (*pushKeyFile == "") {
+		return nil, fmt.Errorf("-push-cert-file 和 -push-key-file 必须同时指定")
+	}
+	if *pushCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(*pushCertFile, *pushKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("加载客户端证书 %s/%s 失败: %v", *pushCertFile, *pushKeyFile, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+
+	"oula-shares-push/dal"
+	"oula-shares-push/promth"
+)
+
+// 记录收到的推送的 Pushgateway
+type fakePushgateway struct {
+	mu     sync.Mutex
+	paths  []string
+	bodies []string
+}
+
+func newFakePushgateway(t *testing.T) (*fakePushgateway, *httptest.Server) {
+	gw := &fakePushgateway{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gw.mu.Lock()
+		gw.paths = append(gw.paths, r.URL.Path)
+		gw.bodies = append(gw.bodies, string(body))
+		gw.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return gw, server
+}
+
+// 记录每次推送的指标、不访问网络的 MetricPusher。与 promth.Client 一样要求同名指标的标签名一致
+type recordingPusher struct {
+	mu     sync.Mutex
+	err    error // 不为 nil 时每次推送都返回这个错误
+	pushes [][]promth.Metric
+}
+
+func (p *recordingPusher) Push(_ context.Context, metrics []promth.Metric) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	labelNames := make(map[string]string)
+	for _, m := range metrics {
+		names := strings.Join(sortedKeys(m.Labels), ",")
+		if prev, ok := labelNames[m.Name]; ok && prev != names {
+			return fmt.Errorf("指标 %s 的标签名 %s 与之前的 %s 不一致", m.Name, names, prev)
+		}
+		labelNames[m.Name] = names
+	}
+	p.pushes = append(p.pushes, metrics)
+	return p.err
+}
+
+// 最后一次推送的指标，按指标名分组
+func (p *recordingPusher) families(t *testing.T) map[string][]promth.Metric {
+	t.Helper()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.pushes) == 0 {
+		t.Fatal("没有推送")
+	}
+	families := make(map[string][]promth.Metric)
+	for _, m := range p.pushes[len(p.pushes)-1] {
+		families[m.Name] = append(families[m.Name], m)
+	}
+	return families
+}
+
+// 最后一次推送中每个序列的文本形式，例如 oula_shares_epoch_count{chain="aleo"} 1410
+func (p *recordingPusher) series(t *testing.T) []string {
+	t.Helper()
+	var series []string
+	for _, metrics := range p.families(t) {
+		for _, m := range metrics {
+			var labels []string
+			for _, name := range sortedKeys(m.Labels) {
+				labels = append(labels, name+"="+strconv.Quote(m.Labels[name]))
+			}
+			series = append(series, m.Name+"{"+strings.Join(labels, ",")+"} "+strconv.FormatFloat(m.Value, 'g', -1, 64))
+		}
+	}
+	return series
+}
+
+// 检查最后一次推送包含 want 中的所有序列
+func (p *recordingPusher) expectSeries(t *testing.T, want ...string) {
+	t.Helper()
+	series := p.series(t)
+	all := strings.Join(series, "\n")
+	for _, w := range want {
+		if !slices.Contains(series, w) {
+			t.Errorf("推送内容中没有 %s:\n%s", w, all)
+		}
+	}
+}
+
+// 一轮查询后推送的指标：链的 gauge、自身的 counter，都带有 HELP；推送失败时本轮返回错误
+func TestRunCyclePushes(t *testing.T) {
+	setFlag(t, "mode", modePushgateway)
+	setFlag(t, "label", "region=eu")
+	setCycleInterval(t, time.Minute)
+	oldCached := cachedData
+	t.Cleanup(func() { cachedData = oldCached })
+	store := dal.NewMemoryStore()
+	store.SetShareCount("aleo", 10, 1410)
+	store.SetShareCount("quai", 5, 97)
+	setSources(t, testSource("", store))
+
+	pusher := &recordingPusher{}
+	if errs := runCycle(context.Background(), sources, pusher); len(errs) != 0 {
+		t.Fatalf("本轮返回错误: %v", errs)
+	}
+	pusher.expectSeries(t,
+		`oula_shares_epoch_count{chain="aleo",region="eu"} 1410`,
+		`oula_shares_epoch_count{chain="quai",region="eu"} 97`,
+	)
+	families := pusher.families(t)
+	for name, metrics := range families {
+		if metrics[0].Help == "" {
+			t.Errorf("%s 没有 HELP", name)
+		}
+	}
+	if got := families[*metricName][0].Type; got != promth.Gauge {
+		t.Errorf("%s 的类型为 %v，应为 gauge", *metricName, got)
+	}
+	if queryErrors := families[queryErrorsMetricName]; len(queryErrors) == 0 || queryErrors[0].Type != promth.Counter {
+		t.Errorf("%s 应为 counter: %+v", queryErrorsMetricName, queryErrors)
+	}
+
+	pusher.err = errors.New("pushgateway 不可用")
+	errs := runCycle(context.Background(), sources, pusher)
+	if len(errs) != 1 || !errors.Is(errs[0], pusher.err) {
+		t.Errorf("推送失败时本轮返回 %v", errs)
+	}
+}
+
+func TestPushLegacyMetricNames(t *testing.T) {
+	setFlag(t, "legacy-metric-names", "true")
+	setFlag(t, "mode", modePushgateway)
+	setFlag(t, "push-instance-hostname", "true")
+	setCycleInterval(t, time.Minute)
+	gw, server := newFakePushgateway(t)
+
+	constLabels, err := pushLabels()
+	if err != nil {
+		t.Fatalf("pushLabels: %v", err)
+	}
+	client, err := promth.NewClient(server.URL, "oula-shares-push",
+		promth.WithFormat(expfmt.NewFormat(expfmt.TypeTextPlain)), promth.WithConstLabels(constLabels), promth.WithRetry(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := testCycleData(time.Now(), map[string]int64{"aleo": 123, "quai": 45})
+	if err := pushMetrics(context.Background(), data, client); err != nil {
+		t.Fatalf("推送旧版指标失败: %v", err)
+	}
+
+	if len(gw.bodies) != 1 {
+		t.Fatalf("收到 %d 次推送，应为 1 次", len(gw.bodies))
+	}
+	if gw.paths[0] != "/metrics/job/oula-shares-push" {
+		t.Errorf("推送路径为 %s", gw.paths[0])
+	}
+	body := gw.bodies[0]
+	for _, want := range []string{"aleo_shares_count{instance=", "quai_shares_count{instance="} {
+		if !strings.Contains(body, want) {
+			t.Errorf("推送内容中没有 %s:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "job=") || strings.Contains(body, "jumperserver") {
+		t.Errorf("推送内容中不应包含旧版指标自带的 job 和 instance 标签:\n%s", body)
+	}
+}
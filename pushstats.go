@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"oula-shares-push/promth"
+)
+
+const (
+	pushAttemptsMetricName = "oula_shares_push_gateway_attempts_total"
+	pushFailuresMetricName = "oula_shares_push_gateway_failures_total"
+	pushDroppedMetricName  = "oula_shares_push_gateway_dropped_total"
+)
+
+// 每个 Pushgateway 地址的推送次数和失败次数，进程生命周期内累计，重启后从 0 开始。
+// Pushgateway 不可用时这些计数无法推送出去，所以写入元数据文件
+type pushCounts struct {
+	attempts int
+	failures int
+	dropped  int
+}
+
+var (
+	pushStats   = make(map[string]*pushCounts)
+	pushStatsMu sync.Mutex
+
+	// 限速导致的放弃只记录一次日志，之后通过计数观察
+	rateLimitLogOnce sync.Once
+)
+
+// 记录一次推送的结果，重试在 promth 内部完成，不单独计数
+func recordPush(addr string, err error) {
+	target := pushTarget(addr)
+
+	pushStatsMu.Lock()
+	defer pushStatsMu.Unlock()
+	counts, ok := pushStats[target]
+	if !ok {
+		counts = &pushCounts{}
+		pushStats[target] = counts
+	}
+	counts.attempts++
+	if err != nil {
+		counts.failures++
+	}
+	if errors.Is(err, promth.ErrRateLimited) {
+		counts.dropped++
+		rateLimitLogOnce.Do(func() {
+			slog.Warn("推送超过 -push-max-rps 的限制，本轮剩余推送已放弃，之后不再重复记录，见 "+pushDroppedMetricName, "target", target)
+		})
+	}
+}
+
+// 用作 target 标签的地址，去掉其中可能带有的密码
+func pushTarget(addr string) string {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return addr
+	}
+	return u.Redacted()
+}
+
+// 推送计数的指标，按 target 排序
+func pushStatsFamilies(now time.Time) []metricFamily {
+	pushStatsMu.Lock()
+	defer pushStatsMu.Unlock()
+
+	targets := make([]string, 0, len(pushStats))
+	for target := range pushStats {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	attempts := metricFamily{name: pushAttemptsMetricName, help: "Total number of pushes to the Pushgateway since the process started", counter: true}
+	failures := metricFamily{name: pushFailuresMetricName, help: "Total number of pushes to the Pushgateway that failed after all retries", counter: true}
+	dropped := metricFamily{name: pushDroppedMetricName, help: "Total number of pushes dropped because -push-max-rps would delay them past the next cycle", counter: true}
+	for _, target := range targets {
+		labels := []labelPair{{"target", target}}
+		attempts.addSample(labels, float64(pushStats[target].attempts), now)
+		failures.addSample(labels, float64(pushStats[target].failures), now)
+		dropped.addSample(labels, float64(pushStats[target].dropped), now)
+	}
+	return []metricFamily{attempts, failures, dropped}
+}
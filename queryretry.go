@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+
+	"oula-shares-push/dal"
+)
+
+var (
+	queryRetries      = flag.Int("query-retries", 3, "Retries of a query that failed with a transient error such as a deadlock or a dropped connection, 0 disables retrying")
+	queryRetryBackoff = flag.Duration("query-retry-backoff", 2*time.Second, "Wait before the first query retry, doubled for each further retry")
+)
+
+// 临时错误（死锁、连接断开等）在本轮内按指数退避重试，其他错误直接返回。
+// 所有重试共用本轮的 -query-timeout，超时后不再重试
+func retryQuery[T any](ctx context.Context, source, name string, query func() (T, error)) (T, error) {
+	var result T
+	attempt := 0
+	operation := func() error {
+		attempt++
+		var err error
+		result, err = query()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || !dal.IsTransient(err) {
+			return backoff.Permanent(err)
+		}
+		return err
+	}
+	notify := func(err error, wait time.Duration) {
+		args := []any{"query", name, "attempt", attempt, "retry_in", wait.Round(time.Millisecond), "err", err}
+		if source != "" {
+			args = append(args, "source", source)
+		}
+		slog.Warn("查询出现临时错误，稍后重试", args...)
+	}
+
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = *queryRetryBackoff
+	b.Multiplier = 2
+	b.RandomizationFactor = 0
+	b.MaxElapsedTime = 0
+	err := backoff.RetryNotify(operation, backoff.WithContext(backoff.WithMaxRetries(b, uint64(max(*queryRetries, 0))), ctx), notify)
+	return result, err
+}
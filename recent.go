@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"oula-shares-push/dal"
+)
+
+const recentMetricName = "oula_shares_recent"
+
+var (
+	recentWindowsFlag listFlag
+	timestampColumn   = flag.String("timestamp-column", "updated_at", "Column with the time a row was written, used by -recent-windows")
+)
+
+func init() {
+	flag.Var(&recentWindowsFlag, "recent-windows", "Export "+recentMetricName+", the shares written within each of these windows per chain, e.g. 5m,1h; needs -timestamp-column")
+}
+
+// 解析后的 -recent-windows，从小到大排列
+var recentWindows []time.Duration
+
+// 解析 -recent-windows，窗口必须为正数并且不能重复
+func parseRecentWindows() error {
+	seen := make(map[time.Duration]bool)
+	for _, value := range recentWindowsFlag {
+		window, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("-recent-windows 中的 %q 不是合法的时长: %v", value, err)
+		}
+		if window <= 0 {
+			return fmt.Errorf("-recent-windows 中的 %q 必须大于 0", value)
+		}
+		if seen[window] {
+			return fmt.Errorf("-recent-windows 中的 %q 重复", value)
+		}
+		seen[window] = true
+		recentWindows = append(recentWindows, window)
+	}
+	sort.Slice(recentWindows, func(i, j int) bool { return recentWindows[i] < recentWindows[j] })
+	return nil
+}
+
+// 检查表中有 -timestamp-column 列，没有时关闭 -recent-windows，而不是每轮都报 SQL 错误
+func checkRecentColumn(ctx context.Context, conn *dbConn) {
+	if len(recentWindows) == 0 {
+		return
+	}
+	if err := dal.CheckColumn(ctx, conn.db, sourceTable, *timestampColumn); err != nil {
+		slog.Warn("-timestamp-column 不可用，-recent-windows 已关闭", "db", conn.String(), "err", err)
+		recentWindows = nil
+	}
+}
+
+// 窗口的标签值，与 Prometheus 的时长写法一致，例如 15m、1h
+func windowLabel(window time.Duration) string {
+	return model.Duration(window).String()
+}
+
+// 查询每个窗口内新增的 share，失败的窗口只记录错误
+func queryRecentShares(ctx context.Context, name string, logger *slog.Logger, store dal.ShareStore, stats map[string]*chainStats) []error {
+	recent, ok := store.(dal.RecentShareStore)
+	if !ok || len(recentWindows) == 0 {
+		return nil
+	}
+
+	var errs []error
+	now := time.Now()
+	for _, window := range recentWindows {
+		window := window
+		counts, err := timedQuery(ctx, name, "recent_shares_"+windowLabel(window), func() (map[string]int64, error) {
+			return recent.RecentShareCounts(ctx, *timestampColumn, now.Add(-window))
+		})
+		if err != nil {
+			logQueryError(logger.With("window", windowLabel(window)), "获取最近一段时间的 share counts", err)
+			errs = append(errs, err)
+			continue
+		}
+		// 窗口内没有新行的链导出为 0
+		for chain, s := range stats {
+			if s.RecentShares == nil {
+				s.RecentShares = make(map[time.Duration]int64, len(recentWindows))
+			}
+			s.RecentShares[window] = counts[chain]
+		}
+	}
+	return errs
+}
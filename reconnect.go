@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"oula-shares-push/dal"
+)
+
+var (
+	dbPingFailures      = flag.Int("db-ping-failures", 3, "Reopen the database connection after this many consecutive failed pings following query errors")
+	dbReconnectAttempts = flag.Int("db-reconnect-attempts", 5, "Connection attempts each time the database connection is reopened")
+	dbReconnectBackoff  = flag.Duration("db-reconnect-backoff", 2*time.Second, "Wait before the second connection attempt, doubled for each further attempt")
+	dbGiveUpAfter       = flag.Duration("db-give-up-after", 0, "Exit when the database has been unreachable for this long, 0 never gives up")
+	startupRetryTimeout = flag.Duration("startup-retry-timeout", 5*time.Minute, "Keep retrying the initial database connection with exponential backoff for this long, 0 retries forever; -once fails on the first error")
+)
+
+// 一个数据源的数据库连接和其上的预编译语句，连续 ping 失败后重新建立。
+// db 为 nil 表示启动时没有连上，下一轮结束后重试
+type dbConn struct {
+	name     string // 数据源名，只有一个数据源时为空
+	fallback bool   // 是否是 -opsDsn-fallback 指定的备用库
+	dsn      string
+	db       *sql.DB
+	stmts    *dal.StmtCache
+	store    dal.ShareStore
+
+	// 最近一轮的 share count 查询是否失败
+	queryFailed bool
+
+	pingFailures   int
+	unhealthySince time.Time
+}
+
+// 启动时建立连接
+func (c *dbConn) connect() error {
+	db, err := initDB(c.dsn)
+	if err != nil {
+		return err
+	}
+	c.setDB(db)
+	return nil
+}
+
+// 日志中使用的名称
+func (c *dbConn) String() string {
+	switch {
+	case c.name == "" && c.fallback:
+		return "备用库"
+	case c.name == "":
+		return "数据库"
+	case c.fallback:
+		return "数据源 " + c.name + " 的备用库"
+	default:
+		return "数据源 " + c.name
+	}
+}
+
+// 查询本轮的数据，没有连接时直接返回错误
+func (c *dbConn) query(ctx context.Context) (*cycleData, []error) {
+	if c.store == nil {
+		if c.fallback {
+			return nil, []error{fmt.Errorf("备用库未连接")}
+		}
+		return nil, []error{fmt.Errorf("数据库未连接")}
+	}
+	logger := slog.Default()
+	if c.name != "" || c.fallback {
+		logger = logger.With("db", c.String())
+	}
+	return queryStats(ctx, c.name, logger, c.store)
+}
+
+// 使用新的连接池，查询通过预编译语句执行，重新连接时预编译语句在新的连接池上重新 prepare
+func (c *dbConn) setDB(db *sql.DB) {
+	c.db = db
+	if c.stmts == nil {
+		c.stmts = dal.NewStmtCache(db)
+	} else {
+		c.stmts.SetDB(db)
+	}
+	store := dal.NewSQLStore(c.stmts, sourceTable, sourceUserTable(), *minShareCount)
+	store.ChainError = func(chain string, err error) { recordChainError(chain, stageScan, err) }
+	c.store = store
+}
+
+// 关闭预编译语句和连接
+func (c *dbConn) close() {
+	if c.db == nil {
+		return
+	}
+	c.stmts.Close()
+	c.db.Close()
+}
+
+// 每轮结束后调用：查询失败时 ping 数据库，连续失败 -db-ping-failures 次后重新连接。
+// database/sql 通常能自己恢复，但故障切换后可能一直返回 invalid connection
+func (c *dbConn) checkHealth(ctx context.Context) {
+	if c.db == nil {
+		c.markUnhealthy()
+		c.reconnect(ctx)
+		return
+	}
+	if !c.queryFailed {
+		c.pingFailures = 0
+		c.unhealthySince = time.Time{}
+		return
+	}
+
+	pingCtx, cancel := context.WithTimeout(ctx, *queryTimeout)
+	err := c.db.PingContext(pingCtx)
+	cancel()
+	if err == nil {
+		// 连接正常，查询失败是其他原因
+		c.pingFailures = 0
+		c.unhealthySince = time.Time{}
+		return
+	}
+
+	c.pingFailures++
+	c.markUnhealthy()
+	slog.Warn("数据库 ping 失败", "db", c.String(), "failures", c.pingFailures, "err", err)
+	if c.pingFailures < *dbPingFailures {
+		return
+	}
+	c.reconnect(ctx)
+}
+
+// 记录开始无法访问的时间，超过 -db-give-up-after 时退出
+func (c *dbConn) markUnhealthy() {
+	if c.unhealthySince.IsZero() {
+		c.unhealthySince = time.Now()
+	}
+	if *dbGiveUpAfter > 0 && time.Since(c.unhealthySince) > *dbGiveUpAfter {
+		fatal("数据库无法访问的时间超过 -db-give-up-after，退出", "db", c.String(),
+			"unhealthy_for", time.Since(c.unhealthySince).Round(time.Second), "give_up_after", *dbGiveUpAfter)
+	}
+}
+
+// 建立新的连接，成功后替换并关闭旧连接；全部尝试失败时保留旧连接，下一轮再试。
+// 两次尝试之间等待时 ctx 被取消则立即返回，不耽误退出
+func (c *dbConn) reconnect(ctx context.Context) {
+	wait := *dbReconnectBackoff
+	for attempt := 1; attempt <= max(*dbReconnectAttempts, 1); attempt++ {
+		slog.Info("正在重新连接数据库", "db", c.String(), "attempt", attempt)
+		db, err := initDB(c.dsn)
+		if err == nil {
+			// 预编译语句绑定在旧的连接池上，需要在新的连接池上重新 prepare
+			c.close()
+			c.setDB(db)
+			c.pingFailures = 0
+			slog.Info("已重新连接数据库", "db", c.String())
+			return
+		}
+		slog.Warn("重新连接数据库失败", "db", c.String(), "attempt", attempt, "err", err)
+
+		if attempt < *dbReconnectAttempts {
+			select {
+			case <-ctx.Done():
+				slog.Info("正在退出，停止重新连接数据库", "db", c.String())
+				return
+			case <-time.After(wait):
+			}
+			wait *= 2
+		}
+	}
+	slog.Error("多次尝试后仍无法连接数据库，下一轮继续", "db", c.String(), "attempts", max(*dbReconnectAttempts, 1))
+}
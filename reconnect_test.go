@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// 重新连接的退避等待期间 ctx 被取消时立即返回，保留原来的连接状态
+func TestReconnectCanceledDuringBackoff(t *testing.T) {
+	setFlag(t, "db-reconnect-attempts", "5")
+	setFlag(t, "db-reconnect-backoff", "10s")
+	// 没有监听的端口，每次连接都立即失败
+	conn := &dbConn{dsn: "user:pw@tcp(127.0.0.1:1)/shares?timeout=1s"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	done := make(chan struct{})
+	start := time.Now()
+	go func() {
+		conn.reconnect(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ctx 取消后 reconnect 没有返回")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("reconnect 用了 %v 才返回", elapsed)
+	}
+	if conn.db != nil || conn.store != nil {
+		t.Error("连接失败时不应替换连接")
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"oula-shares-push/promth"
+)
+
+// SIGHUP 时可以直接生效的参数，其他参数的修改需要重启
+var reloadableFlags = []string{
+	"chains", "exclude-chains", "expected-chains", "zero-missing-chains",
+	"interval", "poll-interval", "interval-jitter",
+	"push-addr", "push-label", "label", "labels",
+	"opsDsn", "opsDsn-file", "output-dir",
+}
+
+// 重新加载时会被替换的运行状态
+type runtimeState struct {
+	pusher   promth.MetricPusher
+	pushOpts []promth.Option
+}
+
+// 等待 d，期间收到 SIGHUP 时重新加载配置后继续等待，按 -write-interval 重新输出缓存的结果；
+// ctx 被取消时提前返回 false
+func waitNextCycle(ctx context.Context, d time.Duration, hup <-chan os.Signal, state *runtimeState) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	deadline := time.Now().Add(d)
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-hup:
+			reloadConfig(ctx, state)
+		case <-watchdogC:
+			notifySystemd("WATCHDOG=1")
+		case <-writeTicks:
+			// 马上要查询的一轮会输出新的结果
+			if time.Until(deadline) > *writeInterval/2 {
+				runWriteCycle(ctx, state.pusher)
+			}
+		case <-timer.C:
+			return true
+		}
+	}
+}
+
+// 重新读取 -config 和 -opsDsn-file 并应用可以直接生效的修改，新的配置不合法时保留原来的配置
+func reloadConfig(ctx context.Context, state *runtimeState) {
+	slog.Info("收到 SIGHUP，重新加载配置")
+	before := snapshotFlags()
+	if err := applyReload(ctx, state, before); err != nil {
+		slog.Error("重新加载配置失败，继续使用原来的配置", "err", err)
+		restoreFlags(before)
+		// 恢复由参数计算出的状态，原来的值已经校验过
+		resolvePollInterval()
+		parseIntervalJitter()
+	}
+}
+
+func applyReload(ctx context.Context, state *runtimeState, before map[string]any) error {
+	if *configFile != "" {
+		values, err := readConfigFile()
+		if err != nil {
+			return err
+		}
+		// 从文件中删除的参数恢复为默认值。-label 和 -labels 共用同一个值，先全部恢复再设置
+		reloadable := make(map[string]bool, len(reloadableFlags))
+		for _, name := range reloadableFlags {
+			reloadable[name] = true
+			if !pinnedFlags[name] {
+				resetFlag(name)
+			}
+		}
+		for _, name := range reloadableFlags {
+			if value, ok := values[name]; ok && !pinnedFlags[name] {
+				if err := setFlagFromConfig(name, value); err != nil {
+					return fmt.Errorf("-config %s 中的 %s: %v", *configFile, name, err)
+				}
+			}
+		}
+		for _, name := range sortedKeys(values) {
+			if reloadable[name] || pinnedFlags[name] {
+				continue
+			}
+			switch values[name].(type) {
+			case []any, map[string]any:
+				continue
+			}
+			if fmt.Sprint(values[name]) != flag.Lookup(name).Value.String() {
+				slog.Warn("参数的修改需要重启才能生效", "flag", name)
+			}
+		}
+	}
+
+	// 先校验所有新的值，全部通过后再应用
+	if err := parseChainFilter(); err != nil {
+		return err
+	}
+	if err := checkReservedLabels("-label", extraLabels, reservedLabels); err != nil {
+		return err
+	}
+	if err := resolvePollInterval(); err != nil {
+		return err
+	}
+	if err := parseIntervalJitter(); err != nil {
+		return err
+	}
+	if err := checkWriteInterval(); err != nil {
+		return err
+	}
+
+	var newDSN string
+	dsnChanged := false
+	if !multiSource() {
+		dsn, err := resolveDSN()
+		if err != nil {
+			return err
+		}
+		newDSN, err = prepareDSN(sourceTable.Dialect, dsn)
+		if err != nil {
+			return err
+		}
+		dsnChanged = newDSN != sources[0].conn.dsn
+	} else if flagChanged(before, "opsDsn") {
+		return fmt.Errorf("多个数据源时不支持重新加载 -opsDsn")
+	}
+
+	pusher, pushOpts := state.pusher, state.pushOpts
+	if pushModeEnabled() && pushClientChanged(before) {
+		if len(pushAddrs) == 0 {
+			return fmt.Errorf("-mode=%s 需要指定 -push-addr", *mode)
+		}
+		for _, addr := range pushAddrs {
+			if err := validatePushAddr(addr); err != nil {
+				return err
+			}
+		}
+		var err error
+		if pushOpts, err = pushOptions(); err != nil {
+			return err
+		}
+		if pusher, err = newPusher(pushOpts); err != nil {
+			return err
+		}
+		if *dryRun {
+			pusher = dryRunPusher{}
+		}
+	}
+
+	// 新的输出目录与启动时一样校验并加锁，放在最后，之后的步骤不会失败，不需要再释放新目录的锁
+	outputDirChanged := fileModeEnabled() && !*dryRun && flagChanged(before, "output-dir")
+	if outputDirChanged {
+		if err := ensureOutputPath(*outputDir); err != nil {
+			return err
+		}
+		if err := relockOutputDir(*outputDir); err != nil {
+			return err
+		}
+	}
+
+	changed := 0
+	for _, name := range reloadableFlags {
+		// -labels 是 -label 的别名，不重复输出
+		if name != "labels" && flagChanged(before, name) {
+			changed++
+			f := flag.Lookup(name)
+			slog.Info("参数已修改", "flag", name, "old", snapshotString(f, before[name]), "new", redactedFlagValue(f))
+		}
+	}
+	state.pusher, state.pushOpts = pusher, pushOpts
+	if outputDirChanged {
+		// 原来目录中的文件不再更新，需要手动清理
+		slog.Warn("-output-dir 已修改，原来目录中的文件不会被删除", "old", before["output-dir"], "new", *outputDir)
+		cleanupTempFiles(*outputDir)
+	}
+	if dsnChanged {
+		// -opsDsn-file 的内容或者 DSN 中的密码变化时参数本身看起来没有变化
+		changed++
+		slog.Info("数据库 DSN 发生变化，重新连接")
+		conn := sources[0].conn
+		conn.dsn = newDSN
+		conn.reconnect(ctx)
+	}
+	if changed == 0 {
+		slog.Info("配置没有变化")
+	}
+	return nil
+}
+
+// 创建推送客户端时用到的参数：-label 决定已注册指标的标签名，Client 不接受同名指标使用不同的标签名；
+// 轮询间隔决定推送重试的截止时间。这些参数变化时需要重新创建客户端
+var pushClientFlags = []string{"push-addr", "push-label", "label", "labels", "interval", "poll-interval"}
+
+func pushClientChanged(before map[string]any) bool {
+	for _, name := range pushClientFlags {
+		if flagChanged(before, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// 保存可以重新加载的参数当前的值，重新加载失败时恢复
+func snapshotFlags() map[string]any {
+	snapshot := make(map[string]any, len(reloadableFlags))
+	for _, name := range reloadableFlags {
+		switch v := flag.Lookup(name).Value.(type) {
+		case *listFlag:
+			snapshot[name] = append(listFlag(nil), *v...)
+		case *dsnFlag:
+			snapshot[name] = append(dsnFlag(nil), *v...)
+		case labelsFlag:
+			labels := make(labelsFlag, len(v))
+			for key, value := range v {
+				labels[key] = value
+			}
+			snapshot[name] = labels
+		default:
+			snapshot[name] = v.String()
+		}
+	}
+	return snapshot
+}
+
+func restoreFlags(snapshot map[string]any) {
+	for name, saved := range snapshot {
+		f := flag.Lookup(name)
+		switch v := f.Value.(type) {
+		case *listFlag:
+			*v = saved.(listFlag)
+		case *dsnFlag:
+			*v = saved.(dsnFlag)
+		case labelsFlag:
+			clear(v)
+			for key, value := range saved.(labelsFlag) {
+				v[key] = value
+			}
+		default:
+			f.Value.Set(saved.(string))
+		}
+	}
+}
+
+// 把参数恢复为默认值
+func resetFlag(name string) {
+	f := flag.Lookup(name)
+	switch v := f.Value.(type) {
+	case *listFlag:
+		*v = nil
+	case *dsnFlag:
+		*v = nil
+	case labelsFlag:
+		clear(v)
+	default:
+		if f.Value.String() != f.DefValue {
+			f.Value.Set(f.DefValue)
+		}
+	}
+}
+
+// 参数的值与快照相比是否变化
+func flagChanged(snapshot map[string]any, name string) bool {
+	f := flag.Lookup(name)
+	return snapshotString(f, snapshot[name]) != redactedFlagValue(f)
+}
+
+// 快照中保存的值的字符串形式，DSN 中的密码被隐藏
+func snapshotString(f *flag.Flag, saved any) string {
+	switch v := saved.(type) {
+	case listFlag:
+		return v.String()
+	case dsnFlag:
+		return redactedFlagValue(&flag.Flag{Name: f.Name, Value: &v})
+	case labelsFlag:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
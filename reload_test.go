@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 重新加载后修改了 -label 和轮询间隔，推送客户端需要重新创建，之后的推送使用新的标签
+func TestReloadLabelsRebuildsPusher(t *testing.T) {
+	gw, server := newFakePushgateway(t)
+	const dsn = "user:pw@tcp(127.0.0.1:3306)/shares"
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(content string) {
+		t.Helper()
+		content = "opsDsn: " + dsn + "\npush-addr: " + server.URL + "\n" + content
+		if err := os.WriteFile(config, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("label: region=eu\n")
+
+	setFlag(t, "config", config)
+	setFlag(t, "mode", modePushgateway)
+	setFlag(t, "push-format", "text")
+	setFlag(t, "push-retries", "0")
+	setFlag(t, "opsDsn", dsn)
+	setFlag(t, "push-addr", server.URL)
+	setFlag(t, "label", "region=eu")
+	setFlag(t, "poll-interval", "1m")
+	setCycleInterval(t, time.Minute)
+	setSources(t, &source{conn: &dbConn{dsn: dsn}})
+
+	pushOpts, err := pushOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pusher, err := newPusher(pushOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := &runtimeState{pusher: pusher, pushOpts: pushOpts}
+	data := testCycleData(time.Now(), map[string]int64{"aleo": 1410})
+	if err := pushMetrics(context.Background(), data, state.pusher); err != nil {
+		t.Fatalf("重新加载前推送失败: %v", err)
+	}
+
+	// 增加一个标签并修改轮询间隔
+	writeConfig("label: region=us,dc=a\npoll-interval: 2m\n")
+	reloadConfig(context.Background(), state)
+	if state.pusher == pusher {
+		t.Fatal("修改 -label 后没有重新创建推送客户端")
+	}
+	if cycleInterval != 2*time.Minute {
+		t.Errorf("轮询间隔为 %v", cycleInterval)
+	}
+	if err := pushMetrics(context.Background(), data, state.pusher); err != nil {
+		t.Fatalf("修改 -label 后推送失败: %v", err)
+	}
+	body := gw.bodies[len(gw.bodies)-1]
+	if want := `oula_shares_epoch_count{chain="aleo",dc="a",region="us"} 1410`; !strings.Contains(body, want) {
+		t.Errorf("推送内容中没有 %s:\n%s", want, body)
+	}
+}
+
+// 重新加载修改 -output-dir 时与启动时一样校验新目录，不可用时保留原来的目录
+func TestReloadOutputDir(t *testing.T) {
+	const dsn = "user:pw@tcp(127.0.0.1:3306)/shares"
+	oldDir, newDir := t.TempDir(), t.TempDir()
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(dir string) {
+		t.Helper()
+		if err := os.WriteFile(config, []byte("opsDsn: "+dsn+"\noutput-dir: "+dir+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	setFlag(t, "config", config)
+	setFlag(t, "mode", modeFile)
+	setFlag(t, "opsDsn", dsn)
+	setFlag(t, "output-dir", oldDir)
+	setCycleInterval(t, time.Minute)
+	setSources(t, &source{conn: &dbConn{dsn: dsn}})
+	if err := lockOutputDir(oldDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(unlockOutputDir)
+	if lockFile == nil {
+		t.Skip("当前平台不支持锁定 -output-dir")
+	}
+	state := &runtimeState{}
+
+	writeConfig(filepath.Join(oldDir, "missing"))
+	reloadConfig(context.Background(), state)
+	if *outputDir != oldDir {
+		t.Fatalf("新目录不存在时 -output-dir 被修改为 %s", *outputDir)
+	}
+
+	writeConfig(newDir)
+	reloadConfig(context.Background(), state)
+	if *outputDir != newDir {
+		t.Fatalf("-output-dir 为 %s，应为 %s", *outputDir, newDir)
+	}
+	// 锁已经转移到新目录，原来的目录可以被另一个实例锁定
+	if f, err := lockDir(filepath.Join(oldDir, lockFileName)); err != nil {
+		t.Errorf("原来目录的锁没有释放: %v", err)
+	} else {
+		f.Close()
+	}
+	if f, err := lockDir(filepath.Join(newDir, lockFileName)); err == nil {
+		f.Close()
+		t.Error("新目录没有被锁定")
+	}
+}
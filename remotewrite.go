@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var (
+	remoteWriteURL             = flag.String("remote-write-url", "", "Send every cycle's samples to this Prometheus remote_write endpoint (e.g. https://mimir/api/v1/push), in addition to -mode")
+	remoteWriteUsername        = flag.String("remote-write-username", "", "Username for -remote-write-url basic auth")
+	remoteWritePasswordFile    = flag.String("remote-write-password-file", "", "File containing the -remote-write-url basic auth password")
+	remoteWriteBearerTokenFile = flag.String("remote-write-bearer-token-file", "", "File containing a token sent as 'Authorization: Bearer <token>' to -remote-write-url, re-read when it changes")
+)
+
+func init() {
+	registerSink(newRemoteWriteSink)
+}
+
+// 把样本编码为 snappy 压缩的 prompb.WriteRequest，POST 到 -remote-write-url
+type remoteWriteSink struct {
+	url      string
+	client   *http.Client
+	username string
+	password string
+}
+
+func newRemoteWriteSink() (sink, error) {
+	if *remoteWriteURL == "" {
+		return nil, nil
+	}
+	if _, err := parseSinkURL("-remote-write-url", *remoteWriteURL); err != nil {
+		return nil, err
+	}
+	s := &remoteWriteSink{url: *remoteWriteURL, username: *remoteWriteUsername}
+	if *remoteWritePasswordFile != "" {
+		password, err := readSecretFile("-remote-write-password-file", *remoteWritePasswordFile)
+		if err != nil {
+			return nil, err
+		}
+		s.password = password
+	}
+	if (s.username != "" || s.password != "") && *remoteWriteBearerTokenFile != "" {
+		return nil, fmt.Errorf("-remote-write-username 和 -remote-write-bearer-token-file 不能同时使用")
+	}
+	client, err := newSinkHTTPClient(*remoteWriteBearerTokenFile)
+	if err != nil {
+		return nil, err
+	}
+	s.client = client
+	return s, nil
+}
+
+func (s *remoteWriteSink) name() string {
+	return "remote_write"
+}
+
+func (s *remoteWriteSink) send(ctx context.Context, data *cycleData) error {
+	body := snappy.Encode(nil, encodeWriteRequest(outputFamilies(data), data.queriedAt))
+	return sendWithRetry(ctx, s.client, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		req.Header.Set("Content-Encoding", "snappy")
+		req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+		req.Header.Set("User-Agent", "oula-shares-push/"+version)
+		if s.username != "" || s.password != "" {
+			req.SetBasicAuth(s.username, s.password)
+		}
+		return req, nil
+	})
+}
+
+// prompb.WriteRequest 中用到的字段编号
+const (
+	writeRequestTimeseries = 1
+	timeSeriesLabels       = 1
+	timeSeriesSamples      = 2
+	labelName              = 1
+	labelValue             = 2
+	sampleValue            = 1
+	sampleTimestamp        = 2
+)
+
+// 按 remote_write 1.0 的 prompb.WriteRequest 编码，每个样本一个时间序列。
+// 链的样本使用查询时间，-write-interval 重复发送同一个查询结果时是完全相同的样本；没有时间的样本使用 defaultTime
+func encodeWriteRequest(families []metricFamily, defaultTime time.Time) []byte {
+	var req []byte
+	for _, family := range families {
+		for _, s := range family.samples {
+			var series []byte
+			for _, l := range sampleLabels(family.name, s) {
+				var label []byte
+				label = protowire.AppendTag(label, labelName, protowire.BytesType)
+				label = protowire.AppendString(label, l.name)
+				label = protowire.AppendTag(label, labelValue, protowire.BytesType)
+				label = protowire.AppendString(label, l.value)
+				series = protowire.AppendTag(series, timeSeriesLabels, protowire.BytesType)
+				series = protowire.AppendBytes(series, label)
+			}
+
+			ts := s.timestamp
+			if ts.IsZero() {
+				ts = defaultTime
+			}
+			var point []byte
+			point = protowire.AppendTag(point, sampleValue, protowire.Fixed64Type)
+			point = protowire.AppendFixed64(point, math.Float64bits(s.value))
+			point = protowire.AppendTag(point, sampleTimestamp, protowire.VarintType)
+			point = protowire.AppendVarint(point, uint64(ts.UnixMilli()))
+			series = protowire.AppendTag(series, timeSeriesSamples, protowire.BytesType)
+			series = protowire.AppendBytes(series, point)
+
+			req = protowire.AppendTag(req, writeRequestTimeseries, protowire.BytesType)
+			req = protowire.AppendBytes(req, series)
+		}
+	}
+	return req
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// 解码后的一个时间序列
+type writtenSeries struct {
+	labels    []labelPair
+	value     float64
+	timestamp int64
+}
+
+// 记录收到的请求，按 statuses 依次返回状态码，用完后返回 200
+type fakeRemoteWrite struct {
+	t        *testing.T
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	series   [][]writtenSeries
+}
+
+func (f *fakeRemoteWrite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r)
+	if len(f.statuses) > 0 {
+		status := f.statuses[0]
+		f.statuses = f.statuses[1:]
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+	}
+	decoded, err := snappy.Decode(nil, body)
+	if err != nil {
+		f.t.Errorf("请求体不是 snappy 压缩的: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	series, err := decodeWriteRequest(decoded)
+	if err != nil {
+		f.t.Errorf("无法解码 WriteRequest: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.series = append(f.series, series)
+}
+
+func newFakeRemoteWrite(t *testing.T, statuses ...int) (*fakeRemoteWrite, sink) {
+	f := &fakeRemoteWrite{t: t, statuses: statuses}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	setFlag(t, "remote-write-url", server.URL+"/api/v1/push")
+	setFlag(t, "sink-retries", "2")
+	setFlag(t, "sink-retry-backoff", "1ms")
+	setCycleInterval(t, time.Minute)
+	s, err := newRemoteWriteSink()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, s
+}
+
+// 按 prompb.WriteRequest 的字段编号解码
+func decodeWriteRequest(b []byte) ([]writtenSeries, error) {
+	var result []writtenSeries
+	err := consumeFields(b, func(num protowire.Number, v []byte) error {
+		if num != writeRequestTimeseries {
+			return nil
+		}
+		var series writtenSeries
+		err := consumeFields(v, func(num protowire.Number, v []byte) error {
+			switch num {
+			case timeSeriesLabels:
+				var l labelPair
+				err := consumeFields(v, func(num protowire.Number, v []byte) error {
+					if num == labelName {
+						l.name = string(v)
+					} else if num == labelValue {
+						l.value = string(v)
+					}
+					return nil
+				})
+				series.labels = append(series.labels, l)
+				return err
+			case timeSeriesSamples:
+				return decodeSample(v, &series)
+			}
+			return nil
+		})
+		result = append(result, series)
+		return err
+	})
+	return result, err
+}
+
+// 依次读取长度前缀的字段
+func consumeFields(b []byte, field func(protowire.Number, []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 || typ != protowire.BytesType {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := field(num, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeSample(b []byte, series *writtenSeries) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == sampleValue && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			series.value = math.Float64frombits(v)
+			b = b[n:]
+		case num == sampleTimestamp && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			series.timestamp = int64(v)
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
+
+func TestRemoteWriteSink(t *testing.T) {
+	setFlag(t, "label", "region=eu")
+	f, s := newFakeRemoteWrite(t)
+	queriedAt := time.UnixMilli(1700000000123)
+	data := testCycleData(queriedAt, map[string]int64{"aleo": 1410, "quai": 97})
+
+	if err := s.send(context.Background(), data); err != nil {
+		t.Fatalf("发送失败: %v", err)
+	}
+	if len(f.requests) != 1 {
+		t.Fatalf("收到 %d 个请求，应为 1 个", len(f.requests))
+	}
+	r := f.requests[0]
+	if r.Method != http.MethodPost || r.URL.Path != "/api/v1/push" {
+		t.Errorf("请求为 %s %s", r.Method, r.URL.Path)
+	}
+	for header, want := range map[string]string{
+		"Content-Type":                      "application/x-protobuf",
+		"Content-Encoding":                  "snappy",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+	} {
+		if got := r.Header.Get(header); got != want {
+			t.Errorf("%s 为 %q，应为 %q", header, got, want)
+		}
+	}
+
+	found := make(map[string]writtenSeries)
+	for _, series := range f.series[0] {
+		if len(series.labels) == 0 || series.labels[0].name != "__name__" {
+			t.Fatalf("第一个标签不是 __name__: %v", series.labels)
+		}
+		for i := 1; i < len(series.labels); i++ {
+			if series.labels[i-1].name >= series.labels[i].name {
+				t.Errorf("标签没有按名称排序: %v", series.labels)
+			}
+		}
+		if series.labels[0].value != *metricName {
+			continue
+		}
+		labels := make(map[string]string)
+		for _, l := range series.labels {
+			labels[l.name] = l.value
+		}
+		if labels["region"] != "eu" {
+			t.Errorf("没有 -label 指定的标签: %v", series.labels)
+		}
+		found[labels["chain"]] = series
+	}
+	for chain, want := range map[string]float64{"aleo": 1410, "quai": 97} {
+		series, ok := found[chain]
+		if !ok {
+			t.Errorf("没有收到 %s 的 %s", chain, *metricName)
+			continue
+		}
+		if series.value != want || series.timestamp != queriedAt.UnixMilli() {
+			t.Errorf("%s 的样本为 %v @%d，应为 %v @%d", chain, series.value, series.timestamp, want, queriedAt.UnixMilli())
+		}
+	}
+}
+
+// 5xx 和 429 重试，其他 4xx 不重试
+func TestRemoteWriteRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantRequests int
+		wantErr      bool
+	}{
+		{"成功", nil, 1, false},
+		{"5xx 后成功", []int{http.StatusServiceUnavailable, http.StatusOK}, 2, false},
+		{"429 后成功", []int{http.StatusTooManyRequests, http.StatusOK}, 2, false},
+		{"一直 5xx", []int{500, 502, 503, 504}, 3, true},
+		{"400 不重试", []int{http.StatusBadRequest}, 1, true},
+		{"401 不重试", []int{http.StatusUnauthorized}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, s := newFakeRemoteWrite(t, tt.statuses...)
+			start := time.Now()
+			err := s.send(context.Background(), testCycleData(time.Now(), map[string]int64{"aleo": 1}))
+			// -sink-retry-backoff 为 1ms，不应等待默认的 500ms
+			if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+				t.Errorf("重试用了 %v，没有使用 -sink-retry-backoff", elapsed)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("发送返回 %v", err)
+			}
+			if len(f.requests) != tt.wantRequests {
+				t.Errorf("收到 %d 个请求，应为 %d 个", len(f.requests), tt.wantRequests)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// 把链名转换成合法的 Prometheus 指标名片段，连续的非法字符替换为一个下划线，
+// 以数字开头时补一个下划线前缀
+func sanitizeMetricName(name string) string {
+	var b strings.Builder
+	invalid := false
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+			b.WriteRune(r)
+			invalid = false
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteRune('_')
+			}
+			b.WriteRune(r)
+			invalid = false
+		default:
+			if !invalid {
+				b.WriteRune('_')
+			}
+			invalid = true
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+// 判断是否是合法的 Prometheus 指标名
+func isValidMetricName(name string) bool {
+	return name != "" && sanitizeMetricName(name) == name
+}
+
+// 按链名排序返回要写入的链，清洗后名称冲突的链（如 "a-b" 和 "a_b"）只保留第一个并记录日志
+func uniqueChains[V any](stats map[string]V) []string {
+	chains := make([]string, 0, len(stats))
+	for chain := range stats {
+		chains = append(chains, chain)
+	}
+	sort.Strings(chains)
+
+	seen := make(map[string]string, len(chains))
+	result := chains[:0]
+	for _, chain := range chains {
+		key := sanitizeMetricName(chain)
+		if other, ok := seen[key]; ok {
+			slog.Warn("链清洗后的名称冲突，已跳过", "chain", chain, "conflicts_with", other, "sanitized", key)
+			continue
+		}
+		seen[key] = chain
+		result = append(result, chain)
+	}
+	return result
+}
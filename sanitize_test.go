@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeMetricName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"aleo", "aleo"},
+		{"quai:main_2", "quai:main_2"},
+		{"aleo-testnet3", "aleo_testnet3"},
+		{"Quai (beta)", "Quai_beta_"},
+		// 连续的非法字符只替换为一个下划线，原有的下划线保留
+		{"a--b", "a_b"},
+		{"a - b", "a_b"},
+		{"a__b", "a__b"},
+		{"a_-b", "a__b"},
+		// 以数字开头时补下划线前缀，开头是其他非法字符时替换为下划线
+		{"3chain", "_3chain"},
+		{"-chain", "_chain"},
+		{".-chain", "_chain"},
+		{"__chain", "__chain"},
+		// unicode 字符按字符而不是字节替换
+		{"链aleo", "_aleo"},
+		{"aleo链链", "aleo_"},
+		{"以太坊", "_"},
+		{"", "_"},
+	}
+	for _, tt := range tests {
+		got := sanitizeMetricName(tt.name)
+		if got != tt.want {
+			t.Errorf("sanitizeMetricName(%q) = %q，应为 %q", tt.name, got, tt.want)
+		}
+		if !isValidMetricName(got) {
+			t.Errorf("sanitizeMetricName(%q) = %q 不是合法的指标名", tt.name, got)
+		}
+	}
+}
+
+// 清洗后名称冲突的链只保留按名称排序的第一个
+func TestUniqueChains(t *testing.T) {
+	stats := map[string]int{"a-b": 1, "a_b": 2, "a b": 3, "quai": 4}
+	if got := strings.Join(uniqueChains(stats), ","); got != "a b,quai" {
+		t.Errorf("uniqueChains 返回 %s", got)
+	}
+}
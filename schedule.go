@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+const skippedCyclesMetricName = "oula_shares_skipped_cycles_total"
+
+// 因为上一轮耗时超过轮询间隔而跳过的轮次
+var skippedCyclesTotal atomic.Int64
+
+// 按固定节奏安排每一轮的开始时间，不受每轮耗时的影响；-interval-jitter 的偏移加在节奏之上，不会累积
+type schedule struct {
+	base   time.Time
+	offset func() time.Duration // 每一轮开始时间的随机偏移
+}
+
+func newSchedule(start time.Time) *schedule {
+	return &schedule{base: start, offset: jitterOffset}
+}
+
+// 计算下一轮的开始时间并返回距离 now 的等待时长，返回值总是大于 0。
+// 上一轮结束时已经错过的开始时间（包括加上偏移后才错过的）直接跳过，保持原来的节奏，
+// 而不是马上开始下一轮。-interval-jitter 小于间隔的一半，没有跳过时相邻两轮至少相隔间隔减去两倍的抖动
+func (s *schedule) advance(now time.Time) time.Duration {
+	for skipped := 0; ; skipped++ {
+		s.base = s.base.Add(cycleInterval)
+		start := s.base.Add(s.offset())
+		if !start.After(now) {
+			continue
+		}
+		if skipped > 0 {
+			skippedCyclesTotal.Add(int64(skipped))
+			slog.Warn("上一轮结束时已经错过下一轮的开始时间，跳过若干轮", "interval", cycleInterval, "skipped", skipped)
+		}
+		return start.Sub(now)
+	}
+}
+
+func skippedCyclesFamily(now time.Time) metricFamily {
+	family := metricFamily{name: skippedCyclesMetricName, help: "Total number of cycles skipped because the previous cycle was still running", counter: true}
+	family.addSample(nil, float64(skippedCyclesTotal.Load()), now)
+	return family
+}
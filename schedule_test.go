@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleAdvance(t *testing.T) {
+	setCycleInterval(t, time.Minute)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		elapsed     time.Duration   // 上一轮结束时距离 start 的时间
+		offsets     []time.Duration // 依次返回的随机偏移
+		wantWait    time.Duration
+		wantSkipped int64
+	}{
+		{"没有抖动", 10 * time.Second, []time.Duration{0}, 50 * time.Second, 0},
+		{"正偏移", 10 * time.Second, []time.Duration{20 * time.Second}, 70 * time.Second, 0},
+		{"负偏移", 10 * time.Second, []time.Duration{-20 * time.Second}, 30 * time.Second, 0},
+		{"负偏移后已经错过", 50 * time.Second, []time.Duration{-20 * time.Second, -20 * time.Second}, 50 * time.Second, 1},
+		{"负偏移后正好是现在", 40 * time.Second, []time.Duration{-20 * time.Second, 0}, 80 * time.Second, 1},
+		{"上一轮超过间隔", 70 * time.Second, []time.Duration{0, 0}, 50 * time.Second, 1},
+		{"上一轮超过间隔但偏移后还没到", 70 * time.Second, []time.Duration{25 * time.Second}, 15 * time.Second, 0},
+		{"上一轮超过间隔并且有偏移", 70 * time.Second, []time.Duration{5 * time.Second, -25 * time.Second}, 25 * time.Second, 1},
+		{"正好在下一轮开始时结束", time.Minute, []time.Duration{0, 0}, time.Minute, 1},
+		{"跳过多轮", 150 * time.Second, []time.Duration{0, 0, 0}, 30 * time.Second, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSchedule(start)
+			calls := 0
+			s.offset = func() time.Duration {
+				if calls >= len(tt.offsets) {
+					t.Fatalf("偏移被使用了 %d 次以上", len(tt.offsets))
+				}
+				calls++
+				return tt.offsets[calls-1]
+			}
+			skippedBefore := skippedCyclesTotal.Load()
+
+			wait := s.advance(start.Add(tt.elapsed))
+			if wait != tt.wantWait {
+				t.Errorf("等待 %v，应为 %v", wait, tt.wantWait)
+			}
+			if wait <= 0 {
+				t.Errorf("等待时长 %v 不是正数", wait)
+			}
+			if skipped := skippedCyclesTotal.Load() - skippedBefore; skipped != tt.wantSkipped {
+				t.Errorf("跳过 %d 轮，应为 %d", skipped, tt.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestParseIntervalJitter(t *testing.T) {
+	setCycleInterval(t, time.Minute)
+	t.Cleanup(func() { intervalJitter = 0 })
+
+	tests := []struct {
+		value    string
+		want     time.Duration
+		wantErr  bool
+		previous time.Duration // 解析前的值，模拟重新加载
+	}{
+		{"", 0, false, 0},
+		// 重新加载时清空 -interval-jitter，原来的抖动不再生效
+		{"", 0, false, 10 * time.Second},
+		{"10s", 10 * time.Second, false, 0},
+		{"29s", 29 * time.Second, false, 0},
+		{"30s", 0, true, 0},
+		{"1m", 0, true, 0},
+		{"10%", 6 * time.Second, false, 0},
+		{"49%", time.Duration(float64(time.Minute) * 0.49), false, 0},
+		{"50%", 0, true, 0},
+		{"-1s", 0, true, 0},
+		{"abc", 0, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			setFlag(t, "interval-jitter", tt.value)
+			intervalJitter = tt.previous
+			err := parseIntervalJitter()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("-interval-jitter=%s 返回 %v", tt.value, err)
+			}
+			if err == nil && intervalJitter != tt.want {
+				t.Errorf("抖动为 %v，应为 %v", intervalJitter, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+var systemdNotify = flag.Bool("systemd-notify", false, "Notify systemd (Type=notify) with READY=1 after the first successful cycle and send WATCHDOG=1 at half of WatchdogSec; "+
+	"a no-op when NOTIFY_SOCKET is unset")
+
+// 是否已经发送过 READY=1
+var sdReady bool
+
+// 按 -systemd-notify 定期发送 WATCHDOG=1，没有启用 watchdog 时为 nil，在 select 中永远不会触发
+var watchdogC <-chan time.Time
+
+// 按 sd_notify 协议向 NOTIFY_SOCKET 发送一条消息，没有设置 NOTIFY_SOCKET 时什么也不做。
+// 直接使用 unix datagram socket，不依赖 libsystemd
+func sdNotify(state string) error {
+	if !*systemdNotify {
+		return nil
+	}
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// 以 @ 开头的是 Linux 的 abstract socket
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// 发送消息，失败只记录日志，不影响主循环
+func notifySystemd(state string) {
+	if err := sdNotify(state); err != nil {
+		slog.Warn("通知 systemd 失败", "state", state, "err", err)
+	}
+}
+
+// 第一次调用时发送 READY=1
+func notifyReady() {
+	if sdReady {
+		return
+	}
+	sdReady = true
+	notifySystemd("READY=1")
+}
+
+// systemd 通过 WATCHDOG_USEC 传递 WatchdogSec，WATCHDOG_PID 不是本进程时表示 watchdog 不是给本进程的
+func watchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("WATCHDOG_USEC=%q 不是合法的微秒数", usec)
+	}
+	return time.Duration(n) * time.Microsecond, nil
+}
+
+// 启用 -systemd-notify 并且 systemd 配置了 WatchdogSec 时，按一半的间隔在主循环中发送 WATCHDOG=1，
+// 主循环卡住（例如阻塞在断开的 TCP 连接上）时 systemd 会重启进程
+func startWatchdog() {
+	if !*systemdNotify || os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+	interval, err := watchdogInterval()
+	if err != nil {
+		slog.Warn("systemd watchdog 未启用", "err", err)
+		return
+	}
+	if interval == 0 {
+		return
+	}
+	slog.Info("已启用 systemd watchdog", "interval", interval/2)
+	watchdogC = time.NewTicker(interval / 2).C
+}
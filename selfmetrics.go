@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	queryDurationMetricName = "oula_shares_db_query_duration_seconds"
+	queryErrorsMetricName   = "oula_shares_db_query_errors_total"
+	cycleDurationMetricName = "oula_shares_cycle_duration_seconds"
+	sourceUpMetricName      = "oula_shares_source_up"
+	fallbackMetricName      = "oula_shares_db_fallback_active"
+)
+
+// 每种查询最近一次的耗时（失败的查询同样记录）和累计失败次数，以及最近一轮的总耗时
+var (
+	selfStatsMu     sync.Mutex
+	queryDurations  = make(map[queryKey]time.Duration)
+	queryErrors     = make(map[queryKey]int)
+	lastCycleLength time.Duration
+)
+
+// 数据源和查询名，分别作为 source 和 query 标签
+type queryKey struct {
+	source string
+	query  string
+}
+
+// 执行一次查询（临时错误时按 -query-retries 重试）并记录耗时和是否失败
+func timedQuery[T any](ctx context.Context, source, name string, query func() (T, error)) (T, error) {
+	start := time.Now()
+	result, err := retryQuery(ctx, source, name, query)
+
+	key := queryKey{source, name}
+	selfStatsMu.Lock()
+	defer selfStatsMu.Unlock()
+	queryDurations[key] = time.Since(start)
+	// 没有失败过的查询同样输出 0
+	queryErrors[key] += btoi(err != nil)
+	return result, err
+}
+
+// 记录一轮的总耗时
+func recordCycleDuration(d time.Duration) {
+	selfStatsMu.Lock()
+	defer selfStatsMu.Unlock()
+	lastCycleLength = d
+}
+
+// 查询耗时和轮次耗时的指标，写入元数据文件，推送模式下随其他指标一起推送。
+// 推送发生在一轮结束之前，推送的轮次耗时是上一轮的
+func selfFamilies(now time.Time) []metricFamily {
+	selfStatsMu.Lock()
+	defer selfStatsMu.Unlock()
+
+	keys := make([]queryKey, 0, len(queryDurations))
+	for key := range queryDurations {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].source != keys[j].source {
+			return keys[i].source < keys[j].source
+		}
+		return keys[i].query < keys[j].query
+	})
+
+	durations := metricFamily{name: queryDurationMetricName, help: "Duration of the last run of each database query, including failed runs", unit: "seconds"}
+	errors := metricFamily{name: queryErrorsMetricName, help: "Total number of failed runs of each database query", counter: true}
+	for _, key := range keys {
+		labels := []labelPair{{"query", key.query}}
+		if key.source != "" {
+			labels = append(labels, labelPair{"source", key.source})
+		}
+		durations.addSample(labels, queryDurations[key].Seconds(), now)
+		errors.addSample(labels, float64(queryErrors[key]), now)
+	}
+	cycle := metricFamily{name: cycleDurationMetricName, help: "Duration of the last completed cycle", unit: "seconds"}
+	cycle.addSample(nil, lastCycleLength.Seconds(), now)
+	families := []metricFamily{durations, errors, cycle, emptyResultsFamily(now), skippedCyclesFamily(now)}
+
+	// 只有一个数据源时由 oula_shares_push_success 反映
+	if multiSource() {
+		up := metricFamily{name: sourceUpMetricName, help: "Whether the last share count query of the data source succeeded"}
+		for _, src := range sources {
+			up.addSample([]labelPair{{"source", src.name}}, float64(btoi(src.up)), now)
+		}
+		families = append(families, up)
+	}
+	if fallback := fallbackFamily(now); len(fallback.samples) > 0 {
+		families = append(families, fallback)
+	}
+
+	return families
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// 配置了 -opsDsn-fallback 的数据源当前是否在使用备用库，只有一个数据源时不带 source 标签
+func fallbackFamily(now time.Time) metricFamily {
+	family := metricFamily{name: fallbackMetricName, help: "Whether the data of the last cycle came from the -opsDsn-fallback replica"}
+	for _, src := range sources {
+		if src.fallback == nil {
+			continue
+		}
+		var labels []labelPair
+		if src.name != "" {
+			labels = []labelPair{{"source", src.name}}
+		}
+		family.addSample(labels, float64(btoi(src.onFallback)), now)
+	}
+	return family
+}
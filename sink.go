@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"golang.org/x/sync/errgroup"
+
+	"oula-shares-push/promth"
+)
+
+var (
+	sinkTimeout      = flag.Duration("sink-timeout", 10*time.Second, "Timeout of each request of the -remote-write-url and other sinks")
+	sinkRetries      = flag.Int("sink-retries", 3, "Number of retries after a failed sink request; network errors, 5xx and 429 responses are retried")
+	sinkRetryBackoff = flag.Duration("sink-retry-backoff", 2*time.Second, "Wait before the first sink retry, doubled for each further retry; a Retry-After response header takes precedence")
+)
+
+const sinkErrorsMetricName = "oula_shares_push_sink_errors_total"
+
+// 除 .prom 文件和 Pushgateway 之外的输出目标，与 -mode 选择的输出同时使用，每轮收到同样的数据
+type sink interface {
+	// 日志和 sink 标签中使用的名称
+	name() string
+	send(ctx context.Context, data *cycleData) error
+}
+
+// 按命令行参数创建 sink，没有配置时返回 nil
+type sinkFactory func() (sink, error)
+
+var (
+	sinkFactories []sinkFactory
+	sinks         []sink
+)
+
+// 由各个 sink 所在的文件在 init 中注册
+func registerSink(factory sinkFactory) {
+	sinkFactories = append(sinkFactories, factory)
+}
+
+// 创建所有配置了的 sink
+func setupSinks() error {
+	for _, factory := range sinkFactories {
+		s, err := factory()
+		if err != nil {
+			return err
+		}
+		if s == nil {
+			continue
+		}
+		slog.Info("启用输出", "sink", s.name())
+		sinks = append(sinks, s)
+	}
+	return nil
+}
+
+// 进程生命周期内每个 sink 失败的次数
+var (
+	sinkErrorsMu sync.Mutex
+	sinkErrors   = make(map[string]int64)
+)
+
+// 同时发送到所有 sink，一个 sink 慢或者失败不影响其他 sink，返回所有失败
+func sendSinks(ctx context.Context, data *cycleData) []error {
+	var errs []error
+	var mu sync.Mutex
+	var g errgroup.Group
+	for _, s := range sinks {
+		s := s
+		if *dryRun {
+			slog.Info("dry-run：跳过发送", "sink", s.name())
+			continue
+		}
+		g.Go(func() error {
+			err := s.send(ctx, data)
+			sinkErrorsMu.Lock()
+			// 没有失败过的 sink 同样输出 0
+			sinkErrors[s.name()] += int64(btoi(err != nil))
+			sinkErrorsMu.Unlock()
+			if err != nil {
+				slog.Error("发送到 sink 时出错", "sink", s.name(), "err", err)
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", s.name(), err))
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+	g.Wait()
+	return errs
+}
+
+func sinkErrorsFamily(now time.Time) metricFamily {
+	sinkErrorsMu.Lock()
+	defer sinkErrorsMu.Unlock()
+	family := metricFamily{name: sinkErrorsMetricName, help: "Total number of cycles whose data could not be sent to the sink, after retries", counter: true}
+	for _, name := range sortedKeys(sinkErrors) {
+		family.addSample([]labelPair{{"sink", name}}, float64(sinkErrors[name]), now)
+	}
+	return family
+}
+
+// 本轮输出到 Pushgateway 和各个 sink 的指标族：所有链的指标，加上查询耗时等自身指标
+func outputFamilies(data *cycleData) []metricFamily {
+	families := chainFamilies(data.chains, data.stats)
+	decorateFamilies(families)
+	self := selfFamilies(time.Now())
+	decorateFamilies(self)
+	return append(families, self...)
+}
+
+// 按标签名排序的样本标签，加上 __name__。值为空的标签等同于不存在，不发送
+func sampleLabels(name string, s sample) []labelPair {
+	labels := []labelPair{{"__name__", name}}
+	for _, l := range s.labels {
+		if l.value != "" {
+			labels = append(labels, l)
+		}
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+	return labels
+}
+
+// 校验 sink 的 URL，只接受 http 和 https
+func parseSinkURL(flagName, value string) (*url.URL, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s %q 不是合法的 URL: %v", flagName, value, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%s %q 必须是 http:// 或 https:// 开头的完整 URL", flagName, value)
+	}
+	return u, nil
+}
+
+// 读取保存密码或 token 的文件，去掉末尾的换行
+func readSecretFile(flagName, path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("读取 %s 失败: %v", flagName, err)
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// sink 使用的 HTTP 客户端，指定 bearerTokenFile 时每个请求都带上其中的 token，文件变化后重新读取
+func newSinkHTTPClient(bearerTokenFile string) (*http.Client, error) {
+	var transport http.RoundTripper = http.DefaultTransport.(*http.Transport).Clone()
+	if bearerTokenFile != "" {
+		var err error
+		if transport, err = promth.BearerTokenFileTransport(transport, bearerTokenFile); err != nil {
+			return nil, err
+		}
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// 状态码不是 2xx 的响应
+type httpStatusError struct {
+	status     int
+	body       string
+	retryAfter time.Duration
+}
+
+func (e *httpStatusError) Error() string {
+	if e.body == "" {
+		return fmt.Sprintf("服务端返回 %d", e.status)
+	}
+	return fmt.Sprintf("服务端返回 %d: %s", e.status, e.body)
+}
+
+// 发送一个 HTTP 请求，网络错误、5xx 和 429 按 -sink-retries 和指数退避重试，响应中有 Retry-After 时按它等待。
+// 每次尝试都会调用 newRequest 重新创建请求；所有重试都不会拖到下一轮轮询
+func sendWithRetry(ctx context.Context, client *http.Client, newRequest func(ctx context.Context) (*http.Request, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, cycleInterval)
+	defer cancel()
+
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = *sinkRetryBackoff
+	b.Multiplier = 2
+	b.MaxElapsedTime = 0
+	// 修改 InitialInterval 后需要 Reset，否则第一次等待仍是默认的 500ms
+	b.Reset()
+	for attempt := 0; ; attempt++ {
+		err := doSinkRequest(ctx, client, newRequest)
+		if err == nil {
+			return nil
+		}
+		wait := b.NextBackOff()
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) {
+			if statusErr.status < 500 && statusErr.status != http.StatusTooManyRequests {
+				return err
+			}
+			if statusErr.retryAfter > 0 {
+				wait = statusErr.retryAfter
+			}
+		}
+		if attempt >= *sinkRetries || !sleepContext(ctx, wait) {
+			return err
+		}
+	}
+}
+
+func doSinkRequest(ctx context.Context, client *http.Client, newRequest func(ctx context.Context) (*http.Request, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, *sinkTimeout)
+	defer cancel()
+	req, err := newRequest(ctx)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return &httpStatusError{
+		status:     resp.StatusCode,
+		body:       strings.TrimSpace(string(body)),
+		retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// Retry-After 可以是秒数或者 HTTP 日期，无法解析时返回 0
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0)
+	}
+	return 0
+}
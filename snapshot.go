@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// 快照文件名中时间戳的格式，按字典序排序即按时间排序
+const snapshotTimeFormat = "20060102T150405.000000000Z"
+
+// 替换文件前把旧内容复制到 -snapshot-dir，快照失败只记录日志，不影响写入
+func snapshotFile(filePath string) {
+	if *snapshotDir == "" {
+		return
+	}
+
+	content, err := os.ReadFile(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		slog.Error("读取文件生成快照时出错", "file", filePath, "err", err)
+		return
+	}
+
+	if err := os.MkdirAll(*snapshotDir, 0755); err != nil {
+		slog.Error("创建快照目录时出错", "dir", *snapshotDir, "err", err)
+		return
+	}
+
+	name := filepath.Base(filePath)
+	snapshotPath := filepath.Join(*snapshotDir, name+"."+time.Now().UTC().Format(snapshotTimeFormat))
+	if err := os.WriteFile(snapshotPath, content, promFileMode); err != nil {
+		slog.Error("写入快照时出错", "file", snapshotPath, "err", err)
+		return
+	}
+
+	pruneSnapshots(name)
+}
+
+// 每个文件只保留最新的 -snapshot-keep 个快照
+func pruneSnapshots(name string) {
+	if *snapshotKeep <= 0 {
+		return
+	}
+
+	snapshots, err := filepath.Glob(filepath.Join(*snapshotDir, name+".*"))
+	if err != nil {
+		slog.Error("扫描快照目录时出错", "dir", *snapshotDir, "err", err)
+		return
+	}
+	if len(snapshots) <= *snapshotKeep {
+		return
+	}
+
+	sort.Strings(snapshots)
+	for _, snapshotPath := range snapshots[:len(snapshots)-*snapshotKeep] {
+		if err := os.Remove(snapshotPath); err != nil {
+			slog.Error("删除旧快照时出错", "file", snapshotPath, "err", err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// 每个文件只保留最新的 -snapshot-keep 个快照，其他文件的快照不受影响
+func TestPruneSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	setFlag(t, "snapshot-dir", dir)
+	setFlag(t, "snapshot-keep", "3")
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var aleo []string
+	for i := 0; i < 5; i++ {
+		name := "aleo_shares_count.prom." + base.Add(time.Duration(i)*time.Minute).Format(snapshotTimeFormat)
+		aleo = append(aleo, name)
+	}
+	quai := []string{
+		"quai_shares_count.prom." + base.Format(snapshotTimeFormat),
+		"quai_shares_count.prom." + base.Add(time.Minute).Format(snapshotTimeFormat),
+	}
+	// 打乱创建顺序，按文件名中的时间判断新旧
+	for _, name := range append([]string{aleo[3], aleo[0], aleo[4], aleo[1], aleo[2]}, quai...) {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pruneSnapshots("aleo_shares_count.prom")
+
+	want := append(append([]string(nil), aleo[2:]...), quai...)
+	sort.Strings(want)
+	if got := dirNames(t, dir); !slices.Equal(got, want) {
+		t.Errorf("剩下的快照为 %v，应为 %v", got, want)
+	}
+}
+
+// 每次替换文件前生成快照，快照的内容为替换前的内容
+func TestSnapshotFile(t *testing.T) {
+	snapshots := t.TempDir()
+	setFlag(t, "snapshot-dir", snapshots)
+	setFlag(t, "snapshot-keep", "2")
+	path := filepath.Join(t.TempDir(), "aleo_shares_count.prom")
+
+	// 文件不存在时没有快照
+	snapshotFile(path)
+	for i := 1; i <= 4; i++ {
+		if err := os.WriteFile(path, []byte(strconv.Itoa(i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		snapshotFile(path)
+	}
+
+	names := dirNames(t, snapshots)
+	if len(names) != 2 {
+		t.Fatalf("快照为 %v，应保留 2 个", names)
+	}
+	for i, want := range []string{"3", "4"} {
+		if content, _ := os.ReadFile(filepath.Join(snapshots, names[i])); string(content) != want {
+			t.Errorf("快照 %s 的内容为 %q，应为 %q", names[i], content, want)
+		}
+	}
+}
+
+// 目录中按名称排序的文件名
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
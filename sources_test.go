@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"oula-shares-push/dal"
+)
+
+func TestPushMissingChainsFromMultipleSources(t *testing.T) {
+	setFlag(t, "expected-chains", "aleo,ironfish,quai")
+	setCycleInterval(t, time.Minute)
+
+	east := dal.NewMemoryStore()
+	east.SetShareCount("aleo", 10, 100)
+	west := dal.NewMemoryStore()
+	west.SetShareCount("quai", 20, 200)
+	setSources(t, testSource("east", east), testSource("west", west))
+
+	data, errs := querySources(context.Background(), sources)
+	if len(errs) > 0 {
+		t.Fatalf("查询出错: %v", errs)
+	}
+	if got := strings.Join(data.chains, ","); got != "aleo,ironfish,quai" {
+		t.Fatalf("链为 %s", got)
+	}
+	if !data.stats["ironfish"].Missing {
+		t.Errorf("ironfish 应该被标记为缺失")
+	}
+
+	pusher := &recordingPusher{}
+	if err := pushMetrics(context.Background(), data, pusher); err != nil {
+		t.Fatalf("推送缺失的链和多个数据源的链失败: %v", err)
+	}
+	pusher.expectSeries(t,
+		`oula_shares_epoch_count{chain="aleo",source="east"} 100`,
+		`oula_shares_epoch_count{chain="ironfish",source=""} 0`,
+		`oula_shares_epoch_count{chain="quai",source="west"} 200`,
+		`oula_shares_chain_present{chain="ironfish",source=""} 0`,
+		`oula_shares_chain_present{chain="quai",source="west"} 1`,
+	)
+}
+
+// 同一个链出现在多个数据源中时两个数据源的数据都输出，source 标签不同
+func TestDuplicateChainAcrossSources(t *testing.T) {
+	setCycleInterval(t, time.Minute)
+	east := dal.NewMemoryStore()
+	east.SetShareCount("aleo", 10, 100)
+	west := dal.NewMemoryStore()
+	west.SetShareCount("aleo", 11, 300)
+	setSources(t, testSource("east", east), testSource("west", west))
+
+	data, errs := querySources(context.Background(), sources)
+	if len(errs) > 0 {
+		t.Fatalf("查询出错: %v", errs)
+	}
+	if got := strings.Join(data.chains, ","); got != "aleo,aleo@west" {
+		t.Fatalf("链为 %s", got)
+	}
+
+	pusher := &recordingPusher{}
+	if err := pushMetrics(context.Background(), data, pusher); err != nil {
+		t.Fatalf("推送失败: %v", err)
+	}
+	pusher.expectSeries(t,
+		`oula_shares_epoch_count{chain="aleo",source="east"} 100`,
+		`oula_shares_epoch_count{chain="aleo",source="west"} 300`,
+	)
+	if all := strings.Join(pusher.series(t), "\n"); strings.Contains(all, "aleo@west") {
+		t.Errorf("标签中出现了内部使用的键:\n%s", all)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 写入每个文件开头的标记行，只有带这个标记的文件才会被当作过期文件删除
+const generatedMarker = "# Generated by oula-shares-push, do not edit."
+
+// 记录输出目录中每个文件连续多少轮没有被写入
+var staleAbsences = make(map[string]int)
+
+// 删除连续 -stale-after 轮都没有出现在查询结果中的链所对应的文件
+func removeStaleFiles(dir string, written map[string]bool) {
+	if *staleAfter <= 0 || *dryRun {
+		return
+	}
+
+	files, err := filepath.Glob(chainFileGlob(dir))
+	if err != nil {
+		slog.Error("扫描目录时出错", "dir", dir, "err", err)
+		return
+	}
+
+	present := make(map[string]bool, len(files))
+	for _, filePath := range files {
+		present[filePath] = true
+		// 元数据文件每轮都会写入，不属于任何一个链
+		if written[filePath] || filePath == metaFilePath() {
+			delete(staleAbsences, filePath)
+			continue
+		}
+		if !hasGeneratedMarker(filePath) {
+			continue
+		}
+
+		staleAbsences[filePath]++
+		if staleAbsences[filePath] < *staleAfter {
+			continue
+		}
+		if err := os.Remove(filePath); err != nil {
+			slog.Error("删除过期文件时出错", "file", filePath, "err", err)
+			continue
+		}
+		delete(staleAbsences, filePath)
+		slog.Info("链已连续多轮未出现在查询结果中，已删除过期文件", "file", filePath, "cycles", *staleAfter)
+	}
+
+	// 被手动删除的文件不再跟踪
+	for filePath := range staleAbsences {
+		if !present[filePath] {
+			delete(staleAbsences, filePath)
+		}
+	}
+}
+
+// 判断文件第一行是否是本工具写入的标记
+func hasGeneratedMarker(filePath string) bool {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return false
+	}
+	return strings.TrimSpace(scanner.Text()) == generatedMarker
+}
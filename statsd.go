@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	statsdAddr          = flag.String("statsd-addr", "", "Send every cycle's values as gauges to this StatsD/DogStatsD UDP address (e.g. 127.0.0.1:8125), in addition to -mode")
+	statsdFormat        = flag.String("statsd-format", "statsd", "Format of -statsd-addr: statsd (the chain is part of the metric name) or dogstatsd (the chain and -label values are tags)")
+	statsdPrefix        = flag.String("statsd-prefix", "oula.shares", "Metric name prefix of the gauges sent to -statsd-addr")
+	statsdMaxPacketSize = flag.Int("statsd-max-packet-size", 1432, "Maximum size in bytes of each -statsd-addr datagram; the gauges of a cycle are split into several datagrams when needed")
+)
+
+// UDP 写入的超时，发送缓冲区满时不会拖住这一轮
+const statsdWriteTimeout = time.Second
+
+func init() {
+	registerSink(newStatsdSink)
+}
+
+// 每轮把所有链的 gauge 按 -statsd-max-packet-size 拆分为若干个数据报发送，不重试
+type statsdSink struct {
+	addr      string
+	dogstatsd bool
+	prefix    string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newStatsdSink() (sink, error) {
+	if *statsdAddr == "" {
+		return nil, nil
+	}
+	if _, _, err := net.SplitHostPort(*statsdAddr); err != nil {
+		return nil, fmt.Errorf("-statsd-addr %q 必须是 host:port 形式: %v", *statsdAddr, err)
+	}
+	if *statsdFormat != "statsd" && *statsdFormat != "dogstatsd" {
+		return nil, fmt.Errorf("-statsd-format 只能是 statsd 或 dogstatsd，不能是 %q", *statsdFormat)
+	}
+	if *statsdMaxPacketSize < 64 || *statsdMaxPacketSize > 65507 {
+		return nil, fmt.Errorf("-statsd-max-packet-size 必须在 64 和 65507 之间")
+	}
+	prefix := strings.Trim(*statsdPrefix, ".")
+	for _, segment := range strings.Split(prefix, ".") {
+		if prefix != "" && graphiteSegment(segment) != segment {
+			return nil, fmt.Errorf("-statsd-prefix %q 中的 %q 只能包含字母、数字、下划线和减号", *statsdPrefix, segment)
+		}
+	}
+	return &statsdSink{addr: *statsdAddr, dogstatsd: *statsdFormat == "dogstatsd", prefix: prefix}, nil
+}
+
+func (s *statsdSink) name() string {
+	return "statsd"
+}
+
+func (s *statsdSink) send(ctx context.Context, data *cycleData) error {
+	packets := statsdPackets(s.statsdLines(data), *statsdMaxPacketSize)
+	if len(packets) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		// UDP 没有握手，这里只会解析地址
+		ctx, cancel := context.WithTimeout(ctx, *sinkTimeout)
+		defer cancel()
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "udp", s.addr)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	// 一个数据报失败时继续发送其他的
+	failed := 0
+	var firstErr error
+	for _, packet := range packets {
+		s.conn.SetWriteDeadline(time.Now().Add(statsdWriteTimeout))
+		if _, err := s.conn.Write(packet); err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if firstErr != nil {
+		// 下一轮重新解析地址，服务端的地址可能已经变化
+		s.conn.Close()
+		s.conn = nil
+		return fmt.Errorf("%d/%d 个数据报发送失败: %w", failed, len(packets), firstErr)
+	}
+	return nil
+}
+
+// 每个链每个值一行。statsd 格式例如 oula.shares.aleo.epoch_count:123|g，
+// dogstatsd 格式例如 oula.shares.epoch_count:123|g|#chain:aleo
+func (s *statsdSink) statsdLines(data *cycleData) []string {
+	var tags string
+	if s.dogstatsd {
+		for _, pair := range extraLabels.pairs() {
+			tags += "," + statsdTag(pair.name, pair.value)
+		}
+	}
+	var lines []string
+	for _, chain := range data.chains {
+		st := data.stats[chain]
+		prefix, suffix := s.prefix, "|g"
+		if s.dogstatsd {
+			suffix += "|#" + statsdTag("chain", st.chainName(chain)) + tags
+			if st.Source != "" {
+				suffix += "," + statsdTag("source", st.Source)
+			}
+		} else {
+			prefix = strings.TrimPrefix(prefix+"."+graphiteSegment(chain), ".")
+		}
+		gauge := func(name string, value int64) {
+			lines = append(lines, strings.TrimPrefix(prefix+"."+name, ".")+":"+strconv.FormatInt(value, 10)+suffix)
+		}
+		gauge("epoch_count", st.EpochCount)
+		if st.HasMaxEpoch {
+			gauge("max_epoch", st.MaxEpoch)
+		}
+		if *exportNonzeroEpochs {
+			gauge("nonzero_epochs", int64(st.NonzeroEpochs))
+		}
+		if *exportMissingEpochs {
+			gauge("missing_epochs", st.MissingEpochs)
+		}
+		if *exportDelta {
+			gauge("delta", st.Delta)
+		}
+	}
+	return lines
+}
+
+// DogStatsD 的 tag 中逗号、竖线和井号有特殊含义，替换为下划线
+func statsdTag(name, value string) string {
+	return strings.NewReplacer(",", "_", "|", "_", "#", "_").Replace(name + ":" + value)
+}
+
+// 按换行把多行合并为不超过 maxSize 的数据报，单独一行超过 maxSize 时单独发送
+func statsdPackets(lines []string, maxSize int) [][]byte {
+	var packets [][]byte
+	var current []byte
+	for _, line := range lines {
+		if len(current) > 0 && len(current)+1+len(line) > maxSize {
+			packets = append(packets, current)
+			current = nil
+		}
+		if len(current) > 0 {
+			current = append(current, '\n')
+		}
+		current = append(current, line...)
+	}
+	if len(current) > 0 {
+		packets = append(packets, current)
+	}
+	return packets
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 在本地 UDP 端口接收数据报
+func listenStatsd(t *testing.T) net.PacketConn {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// 读取 n 个数据报
+func readPackets(t *testing.T, conn net.PacketConn, n int) []string {
+	t.Helper()
+	var packets []string
+	buf := make([]byte, 65536)
+	for len(packets) < n {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		size, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("只收到 %d 个数据报: %v", len(packets), err)
+		}
+		packets = append(packets, string(buf[:size]))
+	}
+	return packets
+}
+
+// 确认没有多余的数据报
+func expectNoPacket(t *testing.T, conn net.PacketConn) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	buf := make([]byte, 65536)
+	if size, _, err := conn.ReadFrom(buf); err == nil {
+		t.Errorf("收到多余的数据报 %q", buf[:size])
+	}
+}
+
+func newTestStatsdSink(t *testing.T, addr, format string) sink {
+	t.Helper()
+	setFlag(t, "statsd-addr", addr)
+	setFlag(t, "statsd-format", format)
+	setFlag(t, "statsd-prefix", "oula.shares")
+	s, err := newStatsdSink()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if conn := s.(*statsdSink).conn; conn != nil {
+			conn.Close()
+		}
+	})
+	return s
+}
+
+func testStatsdData() *cycleData {
+	data := testCycleData(time.Now(), map[string]int64{"aleo": 1410, "quai.main": 97})
+	data.stats["aleo"].MaxEpoch, data.stats["aleo"].HasMaxEpoch = 103, true
+	return data
+}
+
+func TestStatsdSink(t *testing.T) {
+	conn := listenStatsd(t)
+	s := newTestStatsdSink(t, conn.LocalAddr().String(), "statsd")
+
+	if err := s.send(context.Background(), testStatsdData()); err != nil {
+		t.Fatalf("发送失败: %v", err)
+	}
+	want := "oula.shares.aleo.epoch_count:1410|g\n" +
+		"oula.shares.aleo.max_epoch:103|g\n" +
+		"oula.shares.quai_main.epoch_count:97|g"
+	if got := readPackets(t, conn, 1)[0]; got != want {
+		t.Errorf("收到\n%s\n应为\n%s", got, want)
+	}
+	expectNoPacket(t, conn)
+}
+
+func TestDogStatsdSink(t *testing.T) {
+	setFlag(t, "label", "region=eu")
+	conn := listenStatsd(t)
+	s := newTestStatsdSink(t, conn.LocalAddr().String(), "dogstatsd")
+
+	data := testStatsdData()
+	data.stats["aleo"].Source = "east"
+	// tag 中的逗号、竖线和井号替换为下划线
+	data.stats["a,b|c#d"] = &chainStats{EpochCount: 1}
+	data.chains = sortedKeys(data.stats)
+
+	if err := s.send(context.Background(), data); err != nil {
+		t.Fatalf("发送失败: %v", err)
+	}
+	want := "oula.shares.epoch_count:1|g|#chain:a_b_c_d,region:eu\n" +
+		"oula.shares.epoch_count:1410|g|#chain:aleo,region:eu,source:east\n" +
+		"oula.shares.max_epoch:103|g|#chain:aleo,region:eu,source:east\n" +
+		"oula.shares.epoch_count:97|g|#chain:quai.main,region:eu"
+	if got := readPackets(t, conn, 1)[0]; got != want {
+		t.Errorf("收到\n%s\n应为\n%s", got, want)
+	}
+}
+
+// 超过 -statsd-max-packet-size 时按行拆分为多个数据报
+func TestStatsdPacketSplitting(t *testing.T) {
+	setFlag(t, "statsd-max-packet-size", "100")
+	conn := listenStatsd(t)
+	s := newTestStatsdSink(t, conn.LocalAddr().String(), "statsd")
+
+	counts := make(map[string]int64)
+	var want []string
+	for i := 0; i < 20; i++ {
+		chain := fmt.Sprintf("chain%02d", i)
+		counts[chain] = int64(i)
+		want = append(want, fmt.Sprintf("oula.shares.%s.epoch_count:%d|g", chain, i))
+	}
+	if err := s.send(context.Background(), testCycleData(time.Now(), counts)); err != nil {
+		t.Fatalf("发送失败: %v", err)
+	}
+
+	// 每行 35 或 36 字节，一个数据报最多放下 2 行
+	packets := readPackets(t, conn, 10)
+	expectNoPacket(t, conn)
+	var got []string
+	for _, packet := range packets {
+		if len(packet) > 100 {
+			t.Errorf("数据报有 %d 字节，超过了 -statsd-max-packet-size", len(packet))
+		}
+		got = append(got, strings.Split(packet, "\n")...)
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("收到的行为\n%s\n应为\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestStatsdPackets(t *testing.T) {
+	long := strings.Repeat("x", 20)
+	tests := []struct {
+		lines []string
+		want  []string
+	}{
+		{nil, nil},
+		{[]string{"a:1|g"}, []string{"a:1|g"}},
+		{[]string{"a:1|g", "b:2|g"}, []string{"a:1|g\nb:2|g"}},
+		// 正好等于上限
+		{[]string{"a:1|g", "bb:2|g"}, []string{"a:1|g\nbb:2|g"}},
+		{[]string{"a:1|g", "bbb:2|g"}, []string{"a:1|g", "bbb:2|g"}},
+		// 单独一行超过上限时单独发送
+		{[]string{"a:1|g", long, "b:2|g"}, []string{"a:1|g", long, "b:2|g"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, packet := range statsdPackets(tt.lines, 12) {
+			got = append(got, string(packet))
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+			t.Errorf("%q 拆分为 %q，应为 %q", tt.lines, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+-- 本地开发用的示例数据，例如：
+--   sqlite3 /tmp/shares.db < testdata/sample.sql
+--   go run . -db-driver sqlite -opsDsn /tmp/shares.db -outputDir /tmp/prom -dry-run
+CREATE TABLE shares_epoch_counts (
+    chain       TEXT    NOT NULL,
+    epoch       INTEGER NOT NULL,
+    share_count INTEGER NOT NULL,
+    PRIMARY KEY (chain, epoch)
+);
+
+INSERT INTO shares_epoch_counts (chain, epoch, share_count) VALUES
+    ('aleo', 100, 1200),
+    ('aleo', 101, 1350),
+    ('aleo', 102, 0),
+    ('aleo', 103, 1410),
+    ('quai', 500, 88),
+    ('quai', 501, 92),
+    ('quai', 502, 97);
+
+CREATE TABLE shares_user_epoch_counts (
+    chain       TEXT    NOT NULL,
+    epoch       INTEGER NOT NULL,
+    user        TEXT    NOT NULL,
+    share_count INTEGER NOT NULL,
+    PRIMARY KEY (chain, epoch, user)
+);
+
+INSERT INTO shares_user_epoch_counts (chain, epoch, user, share_count) VALUES
+    ('aleo', 103, 'alice', 900),
+    ('aleo', 103, 'bob', 510),
+    ('quai', 502, 'carol', 97);
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sync/atomic"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+// 进程生命周期内写出的文件未通过解析校验的次数
+var validationFailuresTotal atomic.Int64
+
+// 写入文件后重新读取并用 Prometheus 文本解析器校验，校验失败时恢复为写入前的内容
+func writeValidated(filePath string, content []byte) error {
+	if !*validateOutput {
+		return writeFileAtomic(filePath, content)
+	}
+
+	previous, err := os.ReadFile(filePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("读取文件 %s 的原有内容时发生错误: %v", filePath, err)
+	}
+	existed := err == nil
+
+	if err := writeFileAtomic(filePath, content); err != nil {
+		return err
+	}
+
+	written, err := os.ReadFile(filePath)
+	if err == nil {
+		err = parseExposition(written)
+	}
+	if err == nil {
+		return nil
+	}
+
+	validationFailuresTotal.Add(1)
+	slog.Error("文件未通过校验，恢复为写入前的内容", "file", filePath, "err", err)
+	if existed {
+		if rerr := writeFileAtomic(filePath, previous); rerr != nil {
+			slog.Error("恢复文件时出错", "file", filePath, "err", rerr)
+		}
+	} else if rerr := os.Remove(filePath); rerr != nil {
+		slog.Error("删除未通过校验的文件时出错", "file", filePath, "err", rerr)
+	}
+	return fmt.Errorf("文件 %s 未通过校验: %v", filePath, err)
+}
+
+// 用 Prometheus 文本格式解析器解析内容
+func parseExposition(content []byte) error {
+	var parser expfmt.TextParser
+	_, err := parser.TextToMetricFamilies(bytes.NewReader(content))
+	return err
+}
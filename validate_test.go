@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 写入的内容无法解析时恢复为写入前的内容，原来没有文件时删除
+func TestWriteValidated(t *testing.T) {
+	setFlag(t, "validate-output", "true")
+	dir := t.TempDir()
+	const valid = "# TYPE oula_shares_epoch_count gauge\noula_shares_epoch_count{chain=\"aleo\"} 1410\n"
+	const invalid = "oula_shares_epoch_count{chain=\"aleo\" 1410\n"
+
+	path := filepath.Join(dir, "aleo_shares_count.prom")
+	if err := writeValidated(path, []byte(valid)); err != nil {
+		t.Fatalf("写入合法内容失败: %v", err)
+	}
+
+	before := validationFailuresTotal.Load()
+	if err := writeValidated(path, []byte(invalid)); err == nil {
+		t.Fatal("写入无法解析的内容时没有返回错误")
+	}
+	if content, err := os.ReadFile(path); err != nil || string(content) != valid {
+		t.Errorf("校验失败后文件内容为 %q, %v，应恢复为原来的内容", content, err)
+	}
+	if got := validationFailuresTotal.Load() - before; got != 1 {
+		t.Errorf("校验失败计数增加了 %d", got)
+	}
+
+	newPath := filepath.Join(dir, "quai_shares_count.prom")
+	if err := writeValidated(newPath, []byte(invalid)); err == nil {
+		t.Fatal("写入无法解析的内容时没有返回错误")
+	}
+	if _, err := os.Stat(newPath); !os.IsNotExist(err) {
+		t.Errorf("原来没有文件时未通过校验的文件应被删除: %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("目录中留下了 %d 个文件", len(entries))
+	}
+}
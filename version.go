@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+const buildInfoMetricName = "oula_shares_push_build_info"
+
+// 构建信息，由 make build 通过 -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=..." 注入
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+var showVersion = flag.Bool("version", false, "Print the version, git commit and build date, then exit")
+
+// 没有通过 -ldflags 注入时，使用 go build 自动记录的 VCS 信息
+func init() {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if commit == "" {
+				commit = setting.Value
+			}
+		case "vcs.time":
+			if buildDate == "" {
+				buildDate = setting.Value
+			}
+		}
+	}
+}
+
+// 未知的字段显示为 unknown
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// -version 输出的一行
+func versionString() string {
+	return fmt.Sprintf("oula-shares-push %s (commit %s, built %s, %s)", version, orUnknown(commit), orUnknown(buildDate), runtime.Version())
+}
+
+// 值恒为 1，构建信息放在标签中
+func buildInfoFamily(now time.Time) metricFamily {
+	family := metricFamily{name: buildInfoMetricName, help: "Version and git commit of the running binary, always 1"}
+	family.addSample([]labelPair{{"version", version}, {"commit", orUnknown(commit)}}, 1, now)
+	return family
+}
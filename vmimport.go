@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var (
+	vmImportURL          = flag.String("vm-import-url", "", "POST every cycle's metrics as exposition text to this VictoriaMetrics import URL (e.g. http://vm:8428/api/v1/import/prometheus), in addition to -mode")
+	vmImportUsername     = flag.String("vm-import-username", "", "Username for -vm-import-url basic auth")
+	vmImportPasswordFile = flag.String("vm-import-password-file", "", "File containing the -vm-import-url basic auth password")
+)
+
+// -vm-extra-label 指定的标签，由 VictoriaMetrics 通过 extra_label 参数加到导入的每个序列上
+var vmExtraLabels = make(labelsFlag)
+
+func init() {
+	flag.Var(vmExtraLabels, "vm-extra-label", "Label added by VictoriaMetrics to every series imported through -vm-import-url, "+
+		"sent as the extra_label query parameter; repeatable or comma separated (e.g. -vm-extra-label dc=eu1)")
+	registerSink(newVMImportSink)
+}
+
+// 把与文件内容相同的文本 gzip 压缩后 POST 到 VictoriaMetrics，小规模部署可以不用 node_exporter
+type vmImportSink struct {
+	url      string
+	client   *http.Client
+	username string
+	password string
+}
+
+func newVMImportSink() (sink, error) {
+	if *vmImportURL == "" {
+		return nil, nil
+	}
+	u, err := parseSinkURL("-vm-import-url", *vmImportURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkReservedLabels("-vm-extra-label", vmExtraLabels, reservedLabels); err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	for _, p := range vmExtraLabels.pairs() {
+		query.Add("extra_label", p.name+"="+p.value)
+	}
+	u.RawQuery = query.Encode()
+
+	s := &vmImportSink{url: u.String(), username: *vmImportUsername}
+	if *vmImportPasswordFile != "" {
+		if s.password, err = readSecretFile("-vm-import-password-file", *vmImportPasswordFile); err != nil {
+			return nil, err
+		}
+	}
+	if s.client, err = newSinkHTTPClient(""); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *vmImportSink) name() string {
+	return "vm_import"
+}
+
+// 所有链的指标和元数据指标，即 .prom 文件中的全部内容
+func (s *vmImportSink) send(ctx context.Context, data *cycleData) error {
+	content, err := renderFamilies(append(chainFamilies(data.chains, data.stats), metaFamilies(time.Now())...))
+	if err != nil {
+		return fmt.Errorf("渲染指标时发生错误: %v", err)
+	}
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	zw.Write(content)
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	return sendWithRetry(ctx, s.client, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+		req.Header.Set("Content-Encoding", "gzip")
+		req.Header.Set("User-Agent", "oula-shares-push/"+version)
+		if s.username != "" || s.password != "" {
+			req.SetBasicAuth(s.username, s.password)
+		}
+		return req, nil
+	})
+}
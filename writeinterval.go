@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"oula-shares-push/promth"
+)
+
+var writeInterval = flag.Duration("write-interval", 0, "Re-write the files and re-push the last successful query result at this interval between the -poll-interval queries, "+
+	"so mtime-based staleness checks keep passing when the query is expensive; 0 writes only after each query")
+
+// 写入间隔的下限
+const minWriteInterval = time.Second
+
+// 最近一次成功查询的结果，由 -write-interval 的写入重新输出；
+// 以及最近一次输出的时间（UnixNano），使用原子操作，在主循环之外读取也是安全的
+var (
+	cachedData   *cycleData
+	lastOutputAt atomic.Int64
+)
+
+// 按 -write-interval 触发写入，没有启用时为 nil，在 select 中永远不会触发
+var writeTicks <-chan time.Time
+
+// 写入间隔必须比轮询间隔短，否则没有意义
+func checkWriteInterval() error {
+	if *writeInterval == 0 {
+		return nil
+	}
+	if *writeInterval < minWriteInterval {
+		return fmt.Errorf("-write-interval=%v 小于下限 %v", *writeInterval, minWriteInterval)
+	}
+	if *writeInterval >= cycleInterval {
+		return fmt.Errorf("-write-interval=%v 必须小于轮询间隔 %v", *writeInterval, cycleInterval)
+	}
+	return nil
+}
+
+// 启用 -write-interval 时开始按固定节奏触发写入，返回的函数停止计时
+func startWriteTicker() func() {
+	if *writeInterval == 0 {
+		return func() {}
+	}
+	slog.Info("两次查询之间按 -write-interval 重新写入最近一次的查询结果", "write_interval", *writeInterval)
+	ticker := time.NewTicker(*writeInterval)
+	writeTicks = ticker.C
+	return ticker.Stop
+}
+
+// 重新输出最近一次成功查询的结果，还没有成功查询过时跳过。
+// 只更新文件和推送，不计入查询相关的统计，/readyz 仍然以查询轮次为准
+func runWriteCycle(ctx context.Context, pusher promth.MetricPusher) {
+	if cachedData == nil {
+		slog.Debug("还没有成功的查询结果，跳过本次写入")
+		return
+	}
+	// 查询的一轮刚刚输出过时不再重复写入
+	if time.Since(time.Unix(0, lastOutputAt.Load())) < *writeInterval/2 {
+		return
+	}
+	errs := writeOutputs(ctx, cachedData, pusher, false)
+	if ctx.Err() != nil {
+		return
+	}
+	recordDiskErrors(errs)
+	recordWriteResult(errs)
+	publishSelfMetrics()
+	if fileModeEnabled() {
+		writeMetaFile()
+	}
+}
@@ -0,0 +1,528 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/proto"
+)
+
+// 单个 label
+type labelPair struct {
+	name  string
+	value string
+}
+
+// 单个样本，timestamp 为本轮查询时间，只在 -emit-timestamps 开启时输出
+type sample struct {
+	labels    []labelPair
+	value     float64
+	timestamp time.Time
+}
+
+// 同一个指标族的所有样本，由 expfmt 序列化，HELP 和 TYPE 只输出一次
+type metricFamily struct {
+	name    string
+	help    string
+	unit    string // 仅 OpenMetrics 格式输出 UNIT
+	samples []sample
+
+	counter bool // 默认为 gauge
+}
+
+// 追加一个样本
+func (f *metricFamily) addSample(labels []labelPair, value float64, ts time.Time) {
+	f.samples = append(f.samples, sample{labels: labels, value: value, timestamp: ts})
+}
+
+// 转换成 client_model 的 MetricFamily，供 expfmt 序列化
+func (f metricFamily) toDTO() *dto.MetricFamily {
+	mf := &dto.MetricFamily{
+		Name: proto.String(f.name),
+		Help: proto.String(f.help),
+		Type: dto.MetricType_GAUGE.Enum(),
+	}
+	if f.counter {
+		mf.Type = dto.MetricType_COUNTER.Enum()
+	}
+	if f.unit != "" {
+		mf.Unit = proto.String(f.unit)
+	}
+	for _, s := range f.samples {
+		m := &dto.Metric{}
+		if f.counter {
+			m.Counter = &dto.Counter{Value: proto.Float64(s.value)}
+		} else {
+			m.Gauge = &dto.Gauge{Value: proto.Float64(s.value)}
+		}
+		for _, l := range s.labels {
+			m.Label = append(m.Label, &dto.LabelPair{Name: proto.String(l.name), Value: proto.String(l.value)})
+		}
+		if *emitTimestamps {
+			m.TimestampMs = proto.Int64(s.timestamp.UnixMilli())
+		}
+		mf.Metric = append(mf.Metric, m)
+	}
+	return mf
+}
+
+// 给所有指标名加上 -metric-prefix，给所有样本加上 -label 指定的额外标签
+func decorateFamilies(families []metricFamily) {
+	static := extraLabels.pairs()
+	for i := range families {
+		families[i].name = *metricPrefix + families[i].name
+		for j := range families[i].samples {
+			families[i].samples[j].labels = append(families[i].samples[j].labels, static...)
+		}
+	}
+}
+
+// 按 -format 指定的格式渲染所有指标族，所有指标名都会加上 -metric-prefix，
+// 所有样本都会带上 -label 指定的额外标签
+func renderFamilies(families []metricFamily) ([]byte, error) {
+	decorateFamilies(families)
+
+	openMetrics := *outputFormat == formatOpenMetrics
+
+	var b strings.Builder
+	// OpenMetrics 不允许任意注释行，因此只在文本格式中写入生成标记
+	if !openMetrics {
+		b.WriteString(generatedMarker + "\n")
+	}
+	for _, family := range families {
+		if len(family.samples) == 0 {
+			continue
+		}
+		var err error
+		if openMetrics {
+			_, err = expfmt.MetricFamilyToOpenMetrics(&b, family.toDTO(), expfmt.WithUnit())
+		} else {
+			_, err = expfmt.MetricFamilyToText(&b, family.toDTO())
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if openMetrics {
+		if _, err := expfmt.FinalizeOpenMetrics(&b); err != nil {
+			return nil, err
+		}
+	}
+	return []byte(b.String()), nil
+}
+
+// -format 支持的输出格式
+const (
+	formatText        = "text"
+	formatOpenMetrics = "openmetrics"
+)
+
+// 单个链在一轮轮询中采集到的数据
+type chainStats struct {
+	EpochCount  int64
+	EpochShares map[int64]int64 // 最近若干个 epoch 的 share_count，仅 -per-epoch 模式下填充
+	MaxEpoch    int64
+	HasMaxEpoch bool      // 最大高度查询失败时为 false，不输出对应指标
+	UpdatedAt   time.Time // 本轮数据库查询成功的时间
+
+	NonzeroEpochs int           // -epoch-lookback 范围内 share_count 大于 0 的 epoch 个数
+	MissingEpochs int64         // 最近 -missing-epochs-window 个 epoch 中没有 share 的个数
+	QueryDuration time.Duration // 本轮查询 share count 的耗时
+
+	Missing      bool                    // 查询结果中没有这个链，由 -expected-chains 或 -zero-missing-chains 补为 0
+	Source       string                  // 数据来自的数据源，只有一个数据源时为空
+	Chain        string                  // 键不是链名时的链名，见 duplicateChainKey
+	UserShares   map[string]int64        // 最新 epoch 中 share 最多的用户，仅 -per-user 模式下填充
+	RecentShares map[time.Duration]int64 // 每个 -recent-windows 窗口内新增的 share
+
+	Delta         int64         // 与上一轮成功查询相比 share count 的增量
+	DeltaInterval time.Duration // 与上一轮成功查询的间隔，第一轮或计数重置时为 0
+}
+
+const (
+	maxEpochMetricName   = "oula_shares_max_epoch"
+	lastUpdateMetricName = "oula_shares_last_update_timestamp_seconds"
+	nonzeroMetricName    = "oula_shares_nonzero_epochs"
+	durationMetricName   = "oula_shares_query_duration_seconds"
+	deltaMetricName      = "oula_shares_epoch_count_delta"
+	userMetricName       = "oula_shares_user_epoch_count"
+	missingMetricName    = "oula_shares_missing_epochs"
+	deltaSecondsName     = "oula_shares_epoch_count_delta_seconds"
+)
+
+// 封装好的函数，用于写入 Prometheus 格式的数据到文件
+func writeToPromFile(filePath, chain string, stats *chainStats) error {
+	return writeAllMetrics(filePath, []string{chain}, map[string]*chainStats{chain: stats})
+}
+
+// 把所有链的指标渲染到同一个文件中，整体原子替换
+func writeAllMetrics(filePath string, chains []string, stats map[string]*chainStats) error {
+	return writeFamilies(filePath, chainFamilies(chains, stats))
+}
+
+// 渲染指标族并原子写入文件
+func writeFamilies(filePath string, families []metricFamily) error {
+	content, err := renderFamilies(families)
+	if err != nil {
+		return fmt.Errorf("渲染文件 %s 的内容时发生错误: %v", filePath, err)
+	}
+
+	if *dryRun {
+		fmt.Printf("==> %s <==\n%s\n", filePath, content)
+		return nil
+	}
+
+	// 内容与上次写入的完全相同且文件仍然存在时跳过，减少磁盘写入
+	sum := sha256.Sum256(content)
+	lastWrittenMu.Lock()
+	unchanged := lastWritten[filePath] == sum
+	lastWrittenMu.Unlock()
+	if !*forceWrite && unchanged {
+		if _, err := os.Stat(filePath); err == nil {
+			slog.Debug("文件内容未变化，跳过写入", "file", filePath)
+			// -write-interval 的目的是让基于 mtime 的过期检查通过，内容不变时同样更新修改时间
+			if *writeInterval > 0 {
+				now := time.Now()
+				if err := os.Chtimes(filePath, now, now); err != nil {
+					return fmt.Errorf("更新文件 %s 的修改时间时发生错误: %v", filePath, err)
+				}
+			}
+			return nil
+		}
+	}
+	snapshotFile(filePath)
+	err = writeValidated(filePath, content)
+
+	lastWrittenMu.Lock()
+	defer lastWrittenMu.Unlock()
+	if err != nil {
+		delete(lastWritten, filePath)
+		return err
+	}
+	lastWritten[filePath] = sum
+	return nil
+}
+
+// 每个文件上次成功写入内容的哈希
+var (
+	lastWritten   = make(map[string][sha256.Size]byte)
+	lastWrittenMu sync.Mutex
+)
+
+// 按链构建指标族，默认所有链共用同一个指标名，兼容模式下每个链各自一个指标族
+func chainFamilies(chains []string, stats map[string]*chainStats) []metricFamily {
+	var families []metricFamily
+	if *legacyMetricNames {
+		// 兼容旧版本：指标名就是链名
+		for _, chain := range chains {
+			family := metricFamily{
+				name:    fmt.Sprintf("%s_shares_count", sanitizeMetricName(chain)),
+				help:    *metricHelp,
+				counter: *sharesAsCounter,
+			}
+			family.addSample([]labelPair{{"instance", "jumperserver"}, {"job", chain}}, float64(stats[chain].EpochCount), stats[chain].UpdatedAt)
+			families = append(families, family)
+		}
+	} else {
+		family := metricFamily{name: *metricName, help: *metricHelp, counter: *sharesAsCounter}
+		for _, chain := range chains {
+			family.addSample(chainLabels(chain, stats[chain]), float64(stats[chain].EpochCount), stats[chain].UpdatedAt)
+		}
+		families = append(families, family)
+	}
+
+	if len(expectedChains) > 0 {
+		presentFamily := metricFamily{name: chainPresentMetricName, help: "Whether the chain was returned by the last database query"}
+		for _, chain := range chains {
+			presentFamily.addSample(chainLabels(chain, stats[chain]), float64(btoi(!stats[chain].Missing)), stats[chain].UpdatedAt)
+		}
+		families = append(families, presentFamily)
+	}
+
+	if *exportMaxEpoch {
+		maxEpochFamily := metricFamily{name: maxEpochMetricName, help: "Highest epoch recorded for the chain"}
+		for _, chain := range chains {
+			if stats[chain].HasMaxEpoch {
+				maxEpochFamily.addSample(chainLabels(chain, stats[chain]), float64(stats[chain].MaxEpoch), stats[chain].UpdatedAt)
+			}
+		}
+		families = append(families, maxEpochFamily)
+	}
+
+	if *exportNonzeroEpochs {
+		nonzeroFamily := metricFamily{name: nonzeroMetricName, help: "Number of distinct epochs within -epoch-lookback with a nonzero share count for the chain"}
+		for _, chain := range chains {
+			nonzeroFamily.addSample(chainLabels(chain, stats[chain]), float64(stats[chain].NonzeroEpochs), stats[chain].UpdatedAt)
+		}
+		families = append(families, nonzeroFamily)
+	}
+
+	if *exportMissingEpochs {
+		missingFamily := metricFamily{name: missingMetricName, help: "Number of epochs without shares among the most recent epochs of the chain"}
+		for _, chain := range chains {
+			missingFamily.addSample(chainLabels(chain, stats[chain]), float64(stats[chain].MissingEpochs), stats[chain].UpdatedAt)
+		}
+		families = append(families, missingFamily)
+	}
+
+	if *exportQueryDuration {
+		durationFamily := metricFamily{name: durationMetricName, help: "Duration of the share count database query in the last cycle", unit: "seconds"}
+		for _, chain := range chains {
+			durationFamily.addSample(chainLabels(chain, stats[chain]), stats[chain].QueryDuration.Seconds(), stats[chain].UpdatedAt)
+		}
+		families = append(families, durationFamily)
+	}
+
+	if *exportDelta {
+		deltaFamily := metricFamily{name: deltaMetricName, help: "Increase of the share count since the previous successful query of the chain"}
+		intervalFamily := metricFamily{name: deltaSecondsName, help: "Seconds between the two queries the delta was computed from, 0 when there is no previous value", unit: "seconds"}
+		for _, chain := range chains {
+			deltaFamily.addSample(chainLabels(chain, stats[chain]), float64(stats[chain].Delta), stats[chain].UpdatedAt)
+			intervalFamily.addSample(chainLabels(chain, stats[chain]), stats[chain].DeltaInterval.Seconds(), stats[chain].UpdatedAt)
+		}
+		families = append(families, deltaFamily, intervalFamily)
+	}
+
+	if *lastUpdateMetric {
+		lastUpdateFamily := metricFamily{name: lastUpdateMetricName, help: "Unix time of the last successful database query for the chain", unit: "seconds"}
+		for _, chain := range chains {
+			lastUpdateFamily.addSample(chainLabels(chain, stats[chain]), float64(stats[chain].UpdatedAt.Unix()), stats[chain].UpdatedAt)
+		}
+		families = append(families, lastUpdateFamily)
+	}
+
+	if *perUser {
+		family := metricFamily{name: userMetricName, help: "Share count of the top users in the latest epoch of the chain"}
+		for _, chain := range chains {
+			users := make([]string, 0, len(stats[chain].UserShares))
+			for user := range stats[chain].UserShares {
+				users = append(users, user)
+			}
+			sort.Strings(users)
+			for _, user := range users {
+				labels := append(chainLabels(chain, stats[chain]), labelPair{"user", user})
+				family.addSample(labels, float64(stats[chain].UserShares[user]), stats[chain].UpdatedAt)
+			}
+		}
+		families = append(families, family)
+	}
+
+	if len(recentWindows) > 0 {
+		family := metricFamily{name: recentMetricName, help: "Shares written for the chain within the window"}
+		for _, chain := range chains {
+			for _, window := range recentWindows {
+				count, ok := stats[chain].RecentShares[window]
+				if !ok {
+					continue
+				}
+				labels := append(chainLabels(chain, stats[chain]), labelPair{"window", windowLabel(window)})
+				family.addSample(labels, float64(count), stats[chain].UpdatedAt)
+			}
+		}
+		families = append(families, family)
+	}
+
+	if *perEpoch {
+		family := metricFamily{name: *perEpochMetricName, help: "Share count of each recent epoch of the chain", counter: *sharesAsCounter}
+		for _, chain := range chains {
+			epochs := sortedEpochs(stats[chain].EpochShares)
+			for _, epoch := range epochs {
+				labels := append(chainLabels(chain, stats[chain]), labelPair{"epoch", strconv.FormatInt(epoch, 10)})
+				family.addSample(labels, float64(stats[chain].EpochShares[epoch]), stats[chain].UpdatedAt)
+			}
+		}
+		families = append(families, family)
+	}
+
+	return families
+}
+
+// 构造 chain label，配置了多个数据源时再加上 source label。同一个指标的标签名必须一致，
+// 由 -expected-chains 补上的链没有数据源，source 为空
+func chainLabels(chain string, s *chainStats) []labelPair {
+	if multiSource() {
+		return []labelPair{{"chain", s.chainName(chain)}, {"source", s.Source}}
+	}
+	return []labelPair{{"chain", chain}}
+}
+
+// 指标中使用的链名。key 通常就是链名，多个数据源中的同名链除外
+func (s *chainStats) chainName(key string) string {
+	if s.Chain != "" {
+		return s.Chain
+	}
+	return key
+}
+
+// 按从小到大的顺序返回 epoch 列表
+func sortedEpochs(epochShares map[int64]int64) []int64 {
+	epochs := make([]int64, 0, len(epochShares))
+	for epoch := range epochShares {
+		epochs = append(epochs, epoch)
+	}
+	sort.Slice(epochs, func(i, j int) bool { return epochs[i] < epochs[j] })
+	return epochs
+}
+
+// 只保留最近的 n 个 epoch
+func latestEpochs(epochShares map[int64]int64, n int) map[int64]int64 {
+	epochs := sortedEpochs(epochShares)
+	if len(epochs) > n {
+		epochs = epochs[len(epochs)-n:]
+	}
+	result := make(map[int64]int64, len(epochs))
+	for _, epoch := range epochs {
+		result[epoch] = epochShares[epoch]
+	}
+	return result
+}
+
+// 先写入同目录下的临时文件再重命名覆盖目标文件，
+// 保证 node_exporter 只会读到完整的内容
+func writeFileAtomic(filePath string, content []byte) error {
+	tmpPath := tempFilePath(filePath)
+
+	file, err := os.OpenFile(tmpPath, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, promFileMode)
+	if err != nil {
+		return fmt.Errorf("无法打开文件 %s: %w", tmpPath, err)
+	}
+
+	if err := applyFilePermissions(file); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("写入文件 %s 时发生错误: %w", tmpPath, err)
+	}
+	if *fsync {
+		if err := file.Sync(); err != nil {
+			file.Close()
+			os.Remove(tmpPath)
+			return fmt.Errorf("同步文件 %s 到磁盘时发生错误: %w", tmpPath, err)
+		}
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("关闭文件 %s 时发生错误: %w", tmpPath, err)
+	}
+
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("重命名 %s 到 %s 时发生错误: %w", tmpPath, filePath, err)
+	}
+
+	// 同步目录，保证重命名本身在断电后也不会丢失
+	if *fsync {
+		if err := syncDir(filepath.Dir(filePath)); err != nil {
+			return fmt.Errorf("同步目录 %s 到磁盘时发生错误: %w", filepath.Dir(filePath), err)
+		}
+	}
+
+	return nil
+}
+
+// 对目录执行 fsync
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// 临时文件与目标文件位于同一目录，以 . 开头、.tmp 结尾，
+// 不会被 node_exporter 的 textfile collector 读取
+func tempFilePath(filePath string) string {
+	dir, name := filepath.Split(filePath)
+	return filepath.Join(dir, "."+name+".tmp")
+}
+
+// 删除上次异常退出时遗留的临时文件
+func cleanupTempFiles(dir string) {
+	files, err := filepath.Glob(filepath.Join(dir, ".*.prom.tmp"))
+	if err != nil {
+		slog.Error("扫描目录时出错", "dir", dir, "err", err)
+		return
+	}
+
+	for _, filePath := range files {
+		if err := os.Remove(filePath); err != nil {
+			slog.Error("删除临时文件时出错", "file", filePath, "err", err)
+			continue
+		}
+		slog.Info("已删除遗留的临时文件", "file", filePath)
+	}
+}
+
+// 删除输出目录中同一序列出现多次的 .prom 文件（旧版本追加写入时留下的），
+// 第一轮轮询会重新生成只包含当前值的文件
+func cleanupOversizedPromFiles(dir string) {
+	files, err := filepath.Glob(chainFileGlob(dir))
+	if err != nil {
+		slog.Error("扫描目录时出错", "dir", dir, "err", err)
+		return
+	}
+
+	for _, filePath := range files {
+		duplicates, err := countDuplicateSamples(filePath)
+		if err != nil {
+			slog.Error("读取文件时出错", "file", filePath, "err", err)
+			continue
+		}
+		if duplicates == 0 {
+			continue
+		}
+		if err := os.Remove(filePath); err != nil {
+			slog.Error("删除文件时出错", "file", filePath, "err", err)
+			continue
+		}
+		slog.Info("已删除包含重复样本的旧文件", "file", filePath, "duplicates", duplicates)
+	}
+}
+
+// 统计 .prom 文件中重复出现的序列的样本行数（忽略空行和注释行）
+func countDuplicateSamples(filePath string) (int, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	seen := make(map[string]bool)
+	duplicates := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// 序列标识为指标名加 label 部分，即值之前的内容
+		series := line
+		if i := strings.LastIndex(line, "}"); i >= 0 {
+			series = line[:i+1]
+		} else if i := strings.IndexByte(line, ' '); i >= 0 {
+			series = line[:i]
+		}
+		if seen[series] {
+			duplicates++
+		}
+		seen[series] = true
+	}
+	return duplicates, scanner.Err()
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "aleo.prom")
+	if err := writeFileAtomic(path, []byte("aleo_shares_count 1\n")); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	if err := writeFileAtomic(path, []byte("aleo_shares_count 2\n")); err != nil {
+		t.Fatalf("覆盖写入失败: %v", err)
+	}
+	if content, _ := os.ReadFile(path); string(content) != "aleo_shares_count 2\n" {
+		t.Errorf("文件内容为 %q", content)
+	}
+	if _, err := os.Stat(tempFilePath(path)); !os.IsNotExist(err) {
+		t.Errorf("写入完成后临时文件仍然存在: %v", err)
+	}
+}
+
+// 写入失败或进程在重命名前退出时，旧文件保持不变
+func TestWriteFileAtomicInterrupted(t *testing.T) {
+	const old = "aleo_shares_count 1\n"
+
+	t.Run("写入失败", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "aleo.prom")
+		if err := os.WriteFile(path, []byte(old), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// 临时文件的位置被一个非空目录占用，打开临时文件会失败
+		if err := os.MkdirAll(filepath.Join(tempFilePath(path), "x"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeFileAtomic(path, []byte("aleo_shares_count 2\n")); err == nil {
+			t.Fatal("临时文件无法打开时应该返回错误")
+		}
+		if content, _ := os.ReadFile(path); string(content) != old {
+			t.Errorf("写入失败后文件内容变为 %q", content)
+		}
+	})
+
+	t.Run("重命名前退出", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "aleo.prom")
+		if err := os.WriteFile(path, []byte(old), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// 上次进程写了一半的临时文件
+		if err := os.WriteFile(tempFilePath(path), []byte("aleo_sha"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cleanupTempFiles(dir)
+		if content, _ := os.ReadFile(path); string(content) != old {
+			t.Errorf("清理临时文件后文件内容变为 %q", content)
+		}
+		if _, err := os.Stat(tempFilePath(path)); !os.IsNotExist(err) {
+			t.Errorf("遗留的临时文件没有被删除: %v", err)
+		}
+	})
+}
+
+func TestCleanupTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		".aleo.prom.tmp",
+		".quai.prom.tmp",
+		"aleo.prom",
+		".aleo.prom",
+		"aleo.prom.tmp",
+		".other.tmp",
+		"notes.txt",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cleanupTempFiles(dir)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	for _, e := range entries {
+		left = append(left, e.Name())
+	}
+	sort.Strings(left)
+	want := []string{".aleo.prom", ".other.tmp", "aleo.prom", "aleo.prom.tmp", "notes.txt"}
+	if len(left) != len(want) {
+		t.Fatalf("清理后剩下 %v，应为 %v", left, want)
+	}
+	for i := range want {
+		if left[i] != want[i] {
+			t.Fatalf("清理后剩下 %v，应为 %v", left, want)
+		}
+	}
+}
+
+// 每次写入都替换整个文件，旧版本追加写入留下的、同一序列出现多次的文件在启动时删除
+func TestCleanupOversizedPromFiles(t *testing.T) {
+	dir := t.TempDir()
+	appended := "# TYPE aleo_shares_count gauge\naleo_shares_count 1\naleo_shares_count 2\naleo_shares_count 3\n"
+	files := map[string]string{
+		"aleo_shares_count.prom": appended,
+		// 不同的标签是不同的序列
+		"quai_shares_count.prom": "quai_shares_count{a=\"1\"} 1\nquai_shares_count{a=\"2\"} 2\n",
+		// 不是本工具生成的文件
+		"other.prom": appended,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ironfish := chainFilePath(dir, "ironfish")
+	for i := int64(1); i <= 3; i++ {
+		if err := writeToPromFile(ironfish, "ironfish", &chainStats{EpochCount: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if duplicates, err := countDuplicateSamples(ironfish); err != nil || duplicates != 0 {
+		t.Errorf("写入 3 次后有 %d 个重复样本, %v", duplicates, err)
+	}
+
+	cleanupOversizedPromFiles(dir)
+
+	if _, err := os.Stat(filepath.Join(dir, "aleo_shares_count.prom")); !os.IsNotExist(err) {
+		t.Errorf("包含重复样本的文件没有被删除: %v", err)
+	}
+	for _, name := range []string{"quai_shares_count.prom", "other.prom", filepath.Base(ironfish)} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s 不应被删除: %v", name, err)
+		}
+	}
+}
+
+// 用 Prometheus 文本格式解析器解析文件
+func parsePromFile(t *testing.T, path string) (string, map[string]*dto.MetricFamily) {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("%s 不是合法的文本格式: %v\n%s", path, err, content)
+	}
+	return string(content), families
+}
+
+// 写出的文件能被文本格式解析器解析，每个指标族只有一组 HELP 和 TYPE
+func TestWriteToPromFileParses(t *testing.T) {
+	setFlag(t, "export-max-epoch", "true")
+	dir := t.TempDir()
+	data := testCycleData(time.Unix(1700000000, 0), map[string]int64{"aleo": 1410, "quai": 97})
+	data.stats["aleo"].MaxEpoch, data.stats["aleo"].HasMaxEpoch = 103, true
+	data.stats["quai"].MaxEpoch, data.stats["quai"].HasMaxEpoch = 5, true
+
+	path := filepath.Join(dir, "shares.prom")
+	if err := writeAllMetrics(path, data.chains, data.stats); err != nil {
+		t.Fatal(err)
+	}
+	content, families := parsePromFile(t, path)
+
+	epochCount := families[*metricName]
+	if epochCount == nil {
+		t.Fatalf("没有 %s:\n%s", *metricName, content)
+	}
+	if epochCount.GetType() != dto.MetricType_GAUGE || epochCount.GetHelp() != *metricHelp {
+		t.Errorf("%s 的类型为 %v，HELP 为 %q", *metricName, epochCount.GetType(), epochCount.GetHelp())
+	}
+	values := make(map[string]float64)
+	for _, m := range epochCount.GetMetric() {
+		for _, l := range m.GetLabel() {
+			if l.GetName() == "chain" {
+				values[l.GetValue()] = m.GetGauge().GetValue()
+			}
+		}
+	}
+	if len(values) != 2 || values["aleo"] != 1410 || values["quai"] != 97 {
+		t.Errorf("%s 的样本为 %v", *metricName, values)
+	}
+	for name, family := range families {
+		if family.GetHelp() == "" {
+			t.Errorf("%s 没有 HELP", name)
+		}
+		for _, header := range []string{"# HELP " + name + " ", "# TYPE " + name + " "} {
+			if n := strings.Count(content, header); n != 1 {
+				t.Errorf("%q 出现了 %d 次:\n%s", header, n, content)
+			}
+		}
+	}
+
+	// 单个链的文件同样合法
+	aleo := chainFilePath(dir, "aleo")
+	if err := writeToPromFile(aleo, "aleo", data.stats["aleo"]); err != nil {
+		t.Fatal(err)
+	}
+	parsePromFile(t, aleo)
+}
+
+// 标签值中的反斜杠、双引号和换行按文本格式转义，解析后与原来的链名一致
+func TestLabelValueEscaping(t *testing.T) {
+	tests := []struct {
+		chain   string
+		escaped string
+	}{
+		{`alpha"beta`, `chain="alpha\"beta"`},
+		{`a\b`, `chain="a\\b"`},
+		{"a\nb", `chain="a\nb"`},
+		{"\\\"\n", `chain="\\\"\n"`},
+		{"Quai (beta) 链", `chain="Quai (beta) 链"`},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		path := filepath.Join(dir, "escape.prom")
+		if err := writeToPromFile(path, tt.chain, &chainStats{EpochCount: 7}); err != nil {
+			t.Fatalf("写入 %q 失败: %v", tt.chain, err)
+		}
+		content, families := parsePromFile(t, path)
+		if !strings.Contains(content, *metricName+"{"+tt.escaped+"} 7") {
+			t.Errorf("%q 没有按 %s 转义:\n%s", tt.chain, tt.escaped, content)
+		}
+		metrics := families[*metricName].GetMetric()
+		if len(metrics) != 1 || len(metrics[0].GetLabel()) != 1 || metrics[0].GetLabel()[0].GetValue() != tt.chain {
+			t.Errorf("%q 解析后为 %v", tt.chain, metrics)
+		}
+	}
+}
+
+// 解析 OpenMetrics 文本：检查结尾的 # EOF 和 UNIT 元数据，按文本格式的规则去掉 counter 样本的 _total 后缀，
+// 再交给 expfmt 的文本解析器，返回解析结果和每个指标族的 UNIT
+func parseOpenMetrics(t *testing.T, content string) (map[string]*dto.MetricFamily, map[string]string) {
+	t.Helper()
+	body, ok := strings.CutSuffix(content, "# EOF\n")
+	if !ok || strings.Contains(body, "# EOF") {
+		t.Fatalf("OpenMetrics 内容没有以唯一的 # EOF 结尾:\n%s", content)
+	}
+	units := make(map[string]string)
+	counters := make(map[string]bool)
+	var text strings.Builder
+	for _, line := range strings.SplitAfter(body, "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 4 && fields[0] == "#" && fields[1] == "UNIT":
+			if !strings.HasSuffix(fields[2], "_"+fields[3]) {
+				t.Errorf("%s 的名称没有以单位 %s 结尾", fields[2], fields[3])
+			}
+			units[fields[2]] = fields[3]
+			continue
+		case len(fields) == 4 && fields[0] == "#" && fields[1] == "TYPE" && fields[3] == "counter":
+			counters[fields[2]] = true
+		case len(fields) > 0 && fields[0] != "#":
+			name, _, _ := strings.Cut(fields[0], "{")
+			if family, ok := strings.CutSuffix(name, "_total"); ok && counters[family] {
+				line = family + strings.TrimPrefix(line, name)
+			}
+		}
+		text.WriteString(line)
+	}
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(text.String()))
+	if err != nil {
+		t.Fatalf("无法解析 OpenMetrics 内容: %v\n%s", err, content)
+	}
+	return families, units
+}
+
+// -format=openmetrics 写出的文件以 # EOF 结尾，带有 UNIT，解析后的指标族与文本格式一致
+func TestOpenMetricsRoundTrip(t *testing.T) {
+	setFlag(t, "format", formatOpenMetrics)
+	dir := t.TempDir()
+	data := testCycleData(time.Unix(1700000000, 0), map[string]int64{"aleo": 1410, "quai": 97})
+	// counter 的样本名带 _total 后缀
+	errorsFamily := metricFamily{name: "oula_shares_test_errors_total", help: "Test counter", counter: true}
+	errorsFamily.addSample([]labelPair{{"chain", "aleo"}}, 3, data.queriedAt)
+
+	path := filepath.Join(dir, "shares.prom")
+	if err := writeFamilies(path, append(chainFamilies(data.chains, data.stats), errorsFamily)); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), generatedMarker) {
+		t.Errorf("OpenMetrics 内容中不应有注释行:\n%s", content)
+	}
+	families, units := parseOpenMetrics(t, string(content))
+
+	epochCount := families[*metricName]
+	if epochCount == nil || epochCount.GetType() != dto.MetricType_GAUGE || len(epochCount.GetMetric()) != 2 {
+		t.Fatalf("%s 解析为 %v:\n%s", *metricName, epochCount, content)
+	}
+	for _, m := range epochCount.GetMetric() {
+		chain := m.GetLabel()[0].GetValue()
+		if want := float64(data.stats[chain].EpochCount); m.GetGauge().GetValue() != want {
+			t.Errorf("%s 的值为 %v，应为 %v", chain, m.GetGauge().GetValue(), want)
+		}
+	}
+	counter := families[*metricPrefix+"oula_shares_test_errors"]
+	if counter.GetType() != dto.MetricType_COUNTER || len(counter.GetMetric()) != 1 || counter.GetMetric()[0].GetCounter().GetValue() != 3 {
+		t.Errorf("counter 解析为 %v:\n%s", counter, content)
+	}
+	updated := *metricPrefix + lastUpdateMetricName
+	if families[updated] == nil || units[updated] != "seconds" {
+		t.Errorf("%s 的 UNIT 为 %q:\n%s", updated, units[updated], content)
+	}
+}
+
+// 比较打开和关闭 -fsync 时写入一个链文件的耗时
+func BenchmarkWriteFileAtomic(b *testing.B) {
+	content := []byte("# HELP aleo_shares_count Share count of the latest epoch\n# TYPE aleo_shares_count gauge\naleo_shares_count 1410\n")
+	for _, sync := range []bool{false, true} {
+		b.Run("fsync="+strconv.FormatBool(sync), func(b *testing.B) {
+			setFlag(b, "fsync", strconv.FormatBool(sync))
+			path := filepath.Join(b.TempDir(), "aleo.prom")
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := writeFileAtomic(path, content); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}